@@ -0,0 +1,58 @@
+package app
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// BatchLeg is one payment of a batch transfer,
+// Credit is a full account and Amount is in the currency of the debit account
+type BatchLeg struct {
+	Credit string `json:"credit"`
+	Amount string `json:"amount"`
+}
+
+// ReadBatchCSV reads the legs of a batch transfer from CSV lines of "full account,amount".
+// An optional header line "credit,amount" is skipped, and so are blank lines.
+func ReadBatchCSV(r io.Reader) ([]BatchLeg, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+
+	var legs []BatchLeg
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		credit, amount := strings.TrimSpace(record[0]), strings.TrimSpace(record[1])
+		if line == 1 && strings.EqualFold(credit, "credit") && strings.EqualFold(amount, "amount") {
+			continue
+		}
+		if !strings.Contains(credit, "@") {
+			return nil, fmt.Errorf("line %d: %q is not a full account, eg. abc123@ANZBank", line, credit)
+		}
+		legs = append(legs, BatchLeg{Credit: credit, Amount: amount})
+	}
+
+	if len(legs) == 0 {
+		return nil, fmt.Errorf("the batch is empty")
+	}
+	return legs, nil
+}
+
+// BatchTransfer pays every leg from the account in a single transaction,
+// either all the legs are applied or none of them
+func (ap Provider) BatchTransfer(account string, legs []BatchLeg) (string, error) {
+	encoded, err := json.Marshal(legs)
+	if err != nil {
+		return "", fmt.Errorf("cannot encode the batch: %s", err)
+	}
+	return ap.Invoke("batchtransfer", []string{account, string(encoded)})
+}
@@ -0,0 +1,43 @@
+package app
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadBatchCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []BatchLeg
+		wantErr bool
+	}{{name: "plain",
+		input: "bob@CitiBank,10\nalice@ANZBank,2.5\n",
+		want:  []BatchLeg{{Credit: "bob@CitiBank", Amount: "10"}, {Credit: "alice@ANZBank", Amount: "2.5"}}}, {
+		name:  "header and spaces",
+		input: "credit, amount\n bob@CitiBank , 10\n",
+		want:  []BatchLeg{{Credit: "bob@CitiBank", Amount: "10"}}}, {
+		name:    "not a full account",
+		input:   "bob,10\n",
+		wantErr: true}, {
+		name:    "missing amount",
+		input:   "bob@CitiBank\n",
+		wantErr: true}, {
+		name:    "empty",
+		input:   "credit,amount\n",
+		wantErr: true}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReadBatchCSV(strings.NewReader(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ReadBatchCSV() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadBatchCSV() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -15,6 +15,8 @@ import (
 var (
 	// global map for orgName - domain reflection
 	domainMap map[string]string
+	// chaincode functions that only read the ledger and are sent as queries
+	queryFunctions map[string]bool
 )
 
 // Provider (app.Provider) contains the identity info & app running stubs
@@ -30,6 +32,27 @@ func init() {
 	domainMap["ANZBank"] = "anz.italktoyou.cn"
 	domainMap["CitiBank"] = "citi.italktoyou.cn"
 	domainMap["Supervisor"] = "supervi.italktoyou.cn"
+
+	queryFunctions = make(map[string]bool)
+	queryFunctions["approvals"] = true
+	queryFunctions["disputes"] = true
+	queryFunctions["fees"] = true
+	queryFunctions["get"] = true
+	queryFunctions["payments"] = true
+	queryFunctions["policies"] = true
+	queryFunctions["positions"] = true
+	queryFunctions["products"] = true
+	queryFunctions["history"] = true
+	queryFunctions["holds"] = true
+	queryFunctions["orders"] = true
+	queryFunctions["query"] = true
+	queryFunctions["querypage"] = true
+	queryFunctions["rate"] = true
+	queryFunctions["remits"] = true
+	queryFunctions["settlements"] = true
+	queryFunctions["statuslog"] = true
+	queryFunctions["supply"] = true
+	queryFunctions["tx"] = true
 }
 
 // New creates a new app.Provider instance & check the identity
@@ -111,7 +134,7 @@ func (ap Provider) Invoke(ccFunction string, args []string) (resp string, err er
 	}
 
   var response channel.Response
-	if ccFunction == "query" || ccFunction == "get" {
+	if queryFunctions[ccFunction] {
 		response, err = channelClient.Query(request)
 	} else {
 		response, err = channelClient.Execute(request)
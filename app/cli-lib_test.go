@@ -118,6 +118,12 @@ func TestProvider_Invoke_Once(t *testing.T) {
 		name:    "test query",
 		args:    args{ccFunction: "query", args: []string{"in", "alice"}},
 		wantErr: false}, {
+		name:    "test querypage",
+		args:    args{ccFunction: "querypage", args: []string{"in", "alice", "10"}},
+		wantErr: false}, {
+		name:    "test history",
+		args:    args{ccFunction: "history", args: []string{"all", "alice", "2019-07-01", "*", "1"}},
+		wantErr: false}, {
 		name:    "test add",
 		args:    args{ccFunction: "add", args: []string{"alice", "1"}},
 		wantErr: false}, {
@@ -192,7 +198,7 @@ func TestProvider_Invoke_Validate_One_Account(t *testing.T) {
 		args:    args{ccFunction: "reduce", args: []string{"alice", "1"}},
 		wantErr: false}}
 
-	re := regexp.MustCompile(`(\d+)$`) // the num pattern in response
+	re := regexp.MustCompile(`(-?\d+(\.\d+)?)$`) // the decimal pattern in response
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -287,7 +293,7 @@ func TestProvider_Invoke_Validate_Transfer(t *testing.T) {
 		args:    args{ccFunction: "transfer", args: []string{"alice", "carol@ANZBank", "1"}},
 		wantErr: false}}
 
-	re := regexp.MustCompile(`(\d+)$`) // the num pattern in response
+	re := regexp.MustCompile(`(-?\d+(\.\d+)?)$`) // the decimal pattern in response
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
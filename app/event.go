@@ -0,0 +1,93 @@
+package app
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+
+	"github.com/hyperledger/fabric-sdk-go/pkg/client/event"
+	"github.com/hyperledger/fabric-sdk-go/pkg/fabsdk"
+)
+
+// Event is a balance-changing operation reported by the chaincode,
+// Type is the name of the function, eg. "transfer".
+type Event struct {
+	Type        string     `json:"type"`
+	TxID        string     `json:"txID"`
+	Time        string     `json:"time"`
+	Legs        []EventLeg `json:"legs"`
+	BlockNumber uint64     `json:"-"`
+}
+
+// EventLeg is the change of one account, Amount is negative when money leaves it
+type EventLeg struct {
+	Account  string `json:"account"`
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+	Balance  string `json:"balance"`
+}
+
+// Subscribe listens to the chaincode events whose type matches filter,
+// a regular expression such as "transfer|rollback"; ".*" matches every event.
+// The decoded events are delivered over the returned channel, which is closed
+// after the returned unsubscribe function is called; calling it again does nothing.
+func (ap Provider) Subscribe(filter string) (<-chan *Event, func(), error) {
+	channelProvider := ap.sdk.ChannelContext(ap.channelID,
+		fabsdk.WithUser(ap.orgUser),
+		fabsdk.WithOrg(ap.orgID))
+
+	// the payload of chaincode events is only delivered with full blocks
+	eventClient, err := event.New(channelProvider, event.WithBlockEvents())
+	if err != nil {
+		log.Printf("create event client fail: %s\n", err.Error())
+		return nil, nil, err
+	}
+
+	registration, ccEvents, err := eventClient.RegisterChaincodeEvent(ap.chaincodeID, filter)
+	if err != nil {
+		log.Printf("register chaincode event fail: %s\n", err.Error())
+		return nil, nil, err
+	}
+
+	events := make(chan *Event)
+	done := make(chan struct{})
+	go func() {
+		defer close(events)
+		for {
+			select {
+			case <-done:
+				return
+			case ccEvent, ok := <-ccEvents:
+				if !ok {
+					return
+				}
+				decoded := new(Event)
+				if err := json.Unmarshal(ccEvent.Payload, decoded); err != nil {
+					log.Printf("cannot decode event of %s: %s\n", ccEvent.TxID, err)
+					continue
+				}
+				decoded.BlockNumber = ccEvent.BlockNumber
+				select {
+				case events <- decoded:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+
+	// unsubscribe may be called more than once, only the first call unregisters
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			eventClient.Unregister(registration)
+			close(done)
+		})
+	}
+	return events, unsubscribe, nil
+}
+
+// String formats a leg as "account amount currency => balance"
+func (l EventLeg) String() string {
+	return l.Account + " " + l.Amount + " " + l.Currency + " => " + l.Balance
+}
@@ -0,0 +1,133 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// HistoryEntry is one transferring record returned by QueryPage and History
+type HistoryEntry struct {
+	Direction   string `json:"direction"`
+	Account     string `json:"account"`
+	Counterpart string `json:"counterpart"`
+	TxID        string `json:"txID"`
+	Time        string `json:"time"`
+	Type        string `json:"type,omitempty"` // eg. "fee", empty for a transfer
+	Amount      string `json:"amount"`
+	Currency    string `json:"currency"`
+	Rate        string `json:"rate,omitempty"`
+	Fee         string `json:"fee,omitempty"` // charged on top of Amount, in Currency
+	Status      string `json:"status,omitempty"`
+	ReversedBy  string `json:"reversedBy,omitempty"`
+	ReversalOf  string `json:"reversalOf,omitempty"`
+}
+
+// HistoryPage is one page of the transferring history.
+// Bookmark is passed to the next QueryPage call, it is empty on the last page.
+type HistoryPage struct {
+	Entries  []HistoryEntry `json:"entries"`
+	Count    int32          `json:"count"`
+	Bookmark string         `json:"bookmark"`
+}
+
+// QueryPage fetches one page of the "in" / "out" history of an account.
+// Pass an empty bookmark to get the first page.
+func (ap Provider) QueryPage(objectType, account string, pageSize int, bookmark string) (*HistoryPage, error) {
+	args := []string{objectType, account, strconv.Itoa(pageSize)}
+	if bookmark != "" {
+		args = append(args, bookmark)
+	}
+
+	resp, err := ap.Invoke("querypage", args)
+	if err != nil {
+		return nil, err
+	}
+
+	page := new(HistoryPage)
+	if err := json.Unmarshal([]byte(resp), page); err != nil {
+		return nil, fmt.Errorf("cannot decode history page: %s", err)
+	}
+	return page, nil
+}
+
+// History fetches the history of an account filtered by time and amount.
+// direction is "in", "out" or "all"; from and to are dates such as "2019-07-01"
+// or RFC3339 times, and min and max are amounts. An empty filter matches everything.
+func (ap Provider) History(direction, account, from, to, min, max string) ([]HistoryEntry, error) {
+	args := []string{direction, account}
+	for _, filter := range []string{from, to, min, max} {
+		if filter == "" {
+			filter = "*"
+		}
+		args = append(args, filter)
+	}
+
+	resp, err := ap.Invoke("history", args)
+	if err != nil {
+		return nil, err
+	}
+
+	page := new(HistoryPage)
+	if err := json.Unmarshal([]byte(resp), page); err != nil {
+		return nil, fmt.Errorf("cannot decode history: %s", err)
+	}
+	return page.Entries, nil
+}
+
+// Transfer is the record of one transfer returned by Tx
+type Transfer struct {
+	TxID           string `json:"txID"`
+	Debit          string `json:"debit"`
+	Credit         string `json:"credit"`
+	Amount         string `json:"amount"`
+	Currency       string `json:"currency"`
+	CreditAmount   string `json:"creditAmount"`
+	CreditCurrency string `json:"creditCurrency"`
+	Rate           string `json:"rate,omitempty"`
+	Fee            string `json:"fee,omitempty"` // debited in Currency on top of Amount
+	Time           string `json:"time"`
+	Status         string `json:"status"`
+	ReversedBy     string `json:"reversedBy,omitempty"`
+	ReversalOf     string `json:"reversalOf,omitempty"`
+}
+
+// Tx looks up the transfers executed by a transaction, a batch transfer has several.
+// A bank only finds the transfers its accounts take part in.
+func (ap Provider) Tx(txID string) ([]Transfer, error) {
+	resp, err := ap.Invoke("tx", []string{txID})
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Transfer
+	if err := json.Unmarshal([]byte(resp), &records); err != nil {
+		return nil, fmt.Errorf("cannot decode transfers: %s", err)
+	}
+	return records, nil
+}
+
+// String formats an entry the same way as the "query" function does
+func (e HistoryEntry) String() string {
+	arrow := "->"
+	if e.Direction == "in" {
+		arrow = "<-"
+	}
+	str := fmt.Sprintf("%s %s %s\t%s\t%s\t%s %s", e.Account, arrow, e.Counterpart, e.TxID, e.Time, e.Amount, e.Currency)
+	if e.Rate != "" {
+		str += " (" + e.Rate + ")"
+	}
+	if e.Fee != "" {
+		str += " (fee " + e.Fee + ")"
+	}
+	if e.Type != "" {
+		str += " [" + e.Type + "]"
+	}
+	if e.ReversedBy != "" {
+		str += " [reversed by " + e.ReversedBy + "]"
+	}
+	if e.ReversalOf != "" {
+		str += " [reversal of " + e.ReversalOf + "]"
+	}
+	return str
+}
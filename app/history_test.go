@@ -0,0 +1,31 @@
+package app
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestHistoryEntryString(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{{name: "transfer with a fee",
+		input: `{"direction":"out","account":"alice@ANZBank","counterpart":"bob@CitiBank","txID":"tx1","time":"2019-07-01T00:00:00Z","amount":"10.00","currency":"CNY","fee":"1.10"}`,
+		want:  "alice@ANZBank -> bob@CitiBank\ttx1\t2019-07-01T00:00:00Z\t10.00 CNY (fee 1.10)"}, {
+		name:  "fee entry",
+		input: `{"direction":"in","account":"$revenue.CNY@ANZBank","counterpart":"alice@ANZBank","txID":"tx1","time":"2019-07-01T00:00:00Z","type":"fee","amount":"1.10","currency":"CNY"}`,
+		want:  "$revenue.CNY@ANZBank <- alice@ANZBank\ttx1\t2019-07-01T00:00:00Z\t1.10 CNY [fee]"}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var entry HistoryEntry
+			if err := json.Unmarshal([]byte(tt.input), &entry); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if got := entry.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
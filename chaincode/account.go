@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const (
+	// accountVersion is the schema version of the Account document.
+	// Bump it whenever the layout changes and teach migrateAccounts the upgrade.
+	accountVersion = 4
+
+	// defaultCurrency is the ISO 4217 currency of accounts that do not name one
+	defaultCurrency = "CNY"
+
+	// account status values: an account is pending until money first comes in,
+	// dormant after a long time without movements, and closed once archived
+	statusPending = "pending"
+	statusActive  = "active"
+	statusDormant = "dormant"
+	statusFrozen  = "frozen"
+	statusClosed  = "closed"
+
+	// compositeKeyNamespace starts every composite key, simple keys never start with it
+	compositeKeyNamespace = "\x00"
+)
+
+// Account is the JSON document stored under an account key, eg. "alice@ANZBank".
+// Only accounts are stored under simple keys; every other record lives under
+// a composite key, so a range query over simple keys visits exactly the accounts.
+// The balance may go negative down to -CreditLimit, which is an arranged overdraft.
+// An account with a Product earns interest: Accrued is the exact interest accrued
+// and not posted yet, a big.Rat string such as "7/73", up to the date AccruedTo.
+// LastActivity is the time money last moved in or out, not counting the interest.
+// FrozenFrom is the status of a frozen account before the freeze, which unfreeze restores.
+type Account struct {
+	Version      int    `json:"version"`
+	Balance      Money  `json:"balance"`
+	CreditLimit  Money  `json:"creditLimit"`
+	Currency     string `json:"currency"`
+	Owner        string `json:"owner"`             // MSPID of the owning bank
+	OwnerID      string `json:"ownerID,omitempty"` // identity of the owning client
+	Status       string `json:"status"`
+	Product      string `json:"product,omitempty"`
+	Accrued      string `json:"accrued,omitempty"`
+	AccruedTo    string `json:"accruedTo,omitempty"`
+	LastActivity string `json:"lastActivity,omitempty"`
+	FrozenFrom   string `json:"frozenFrom,omitempty"`
+	CreatedAt    string `json:"createdAt"`
+	UpdatedAt    string `json:"updatedAt"`
+}
+
+// upgrade brings an account decoded from an older schema version up to date.
+// version 2 added the credit limit, which is zero for older accounts.
+// version 3 added the interest product, older accounts have none.
+// version 4 added the last activity, which is taken from the last update of older accounts,
+// and the status before a freeze, older frozen accounts were active.
+func (acc *Account) upgrade() {
+	if acc.Version < 2 {
+		acc.CreditLimit = Money{Scale: acc.Balance.Scale}
+	}
+	if acc.Version < 4 {
+		acc.LastActivity = acc.UpdatedAt
+		if acc.Status == "" {
+			acc.Status = statusActive
+		}
+		if acc.Status == statusFrozen {
+			acc.FrozenFrom = statusActive
+		}
+	}
+	acc.Version = accountVersion
+}
+
+// available returns how much can be taken out of an account, including the overdraft.
+func (acc *Account) available() (Money, error) {
+	return acc.Balance.Add(acc.CreditLimit)
+}
+
+// bankOf returns the bank part of a full account, eg. "ANZBank" for "alice@ANZBank".
+func bankOf(account string) string {
+	return account[strings.LastIndex(account, "@")+1:]
+}
+
+// txTime returns the timestamp of the current transaction in UTC.
+// It is the same on every endorsing peer, unlike time.Now().
+func txTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Get transaction timestamp failed! With error: %s", err)
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
+// newAccount builds a new active account owned by the bank of the account key.
+func newAccount(stub shim.ChaincodeStubInterface, account string, balance Money, currency string) (*Account, error) {
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Account{
+		Version:     accountVersion,
+		Balance:     balance,
+		CreditLimit: Money{Scale: balance.Scale},
+		Currency:    currency,
+		Owner:       bankOf(account) + "MSP",
+		Status:      statusActive,
+		CreatedAt:   now.Format(time.RFC3339),
+		UpdatedAt:   now.Format(time.RFC3339),
+	}, nil
+}
+
+// getAccount reads and decodes an account from the worldstate database.
+func getAccount(stub shim.ChaincodeStubInterface, account string) (*Account, error) {
+	value, err := stub.GetState(account)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get asset: %s with error: %s", account, err)
+	}
+	if value == nil {
+		// a closed account is archived, its name is not free again
+		if archived, err := getArchived(stub, account); err == nil && archived != nil {
+			return nil, fmt.Errorf("Account %s is closed!", account)
+		}
+		return nil, fmt.Errorf("Asset not found: %s", account)
+	}
+
+	acc := new(Account)
+	if err := json.Unmarshal(value, acc); err != nil {
+		return nil, fmt.Errorf("Corrupted asset: %s with error: %s", account, err)
+	}
+	acc.upgrade()
+	return acc, nil
+}
+
+// getArchived reads a closed account from the archive, it returns nil if the account was never closed.
+func getArchived(stub shim.ChaincodeStubInterface, account string) (*Account, error) {
+	key, err := stub.CreateCompositeKey("archive", []string{account})
+	if err != nil {
+		return nil, fmt.Errorf("Create archive key failed! With error: %s", err)
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get archived asset: %s with error: %s", account, err)
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	acc := new(Account)
+	if err := json.Unmarshal(value, acc); err != nil {
+		return nil, fmt.Errorf("Corrupted archived asset: %s with error: %s", account, err)
+	}
+	return acc, nil
+}
+
+// archiveAccount moves a closed account from its key into the archive.
+func archiveAccount(stub shim.ChaincodeStubInterface, account string, acc *Account) error {
+	now, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	acc.UpdatedAt = now.Format(time.RFC3339)
+
+	key, err := stub.CreateCompositeKey("archive", []string{account})
+	if err != nil {
+		return fmt.Errorf("Create archive key failed! With error: %s", err)
+	}
+	value, err := json.Marshal(acc)
+	if err != nil {
+		return fmt.Errorf("Failed to encode asset: %s with error: %s", account, err)
+	}
+	if err := stub.PutState(key, value); err != nil {
+		return fmt.Errorf("Failed to archive asset: %s with error: %s", account, err)
+	}
+	if err := stub.DelState(account); err != nil {
+		return fmt.Errorf("Failed to delete asset: %s with error: %s", account, err)
+	}
+	return nil
+}
+
+// putAccount stamps the update time of an account and writes it back.
+func putAccount(stub shim.ChaincodeStubInterface, account string, acc *Account) error {
+	now, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	acc.UpdatedAt = now.Format(time.RFC3339)
+
+	value, err := json.Marshal(acc)
+	if err != nil {
+		return fmt.Errorf("Failed to encode asset: %s with error: %s", account, err)
+	}
+	if err := stub.PutState(account, value); err != nil {
+		return fmt.Errorf("Failed to set asset: %s with error: %s", account, err)
+	}
+	return nil
+}
+
+// migrateAccounts upgrades accounts written by older chaincode versions,
+// which stored the bare balance string, eg. "100", under the account key,
+// or a JSON document of an older schema version.
+// Up to date accounts are left untouched, so it is safe to run
+// on every instantiation or upgrade.
+func migrateAccounts(stub shim.ChaincodeStubInterface) (int, error) {
+	// an empty range visits every simple key, composite keys are skipped by a peer
+	it, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return 0, fmt.Errorf("Cannot get accounts by range! With error: %s", err)
+	}
+	defer it.Close()
+
+	migrated := 0
+	for it.HasNext() {
+		item, err := it.Next()
+		if err != nil {
+			return migrated, fmt.Errorf("Get next of iterator failed! With error: %s", err)
+		}
+		// but not by a MockStub, and a record is no account to upgrade
+		if strings.HasPrefix(item.GetKey(), compositeKeyNamespace) {
+			continue
+		}
+		if strings.HasPrefix(string(item.GetValue()), "{") {
+			// already a JSON document, maybe of an older version
+			acc := new(Account)
+			if err := json.Unmarshal(item.GetValue(), acc); err != nil {
+				return migrated, fmt.Errorf("Corrupted asset: %s with error: %s", item.GetKey(), err)
+			}
+			if acc.Version >= accountVersion {
+				continue
+			}
+			acc.upgrade()
+			if err := putAccount(stub, item.GetKey(), acc); err != nil {
+				return migrated, err
+			}
+			migrated++
+			continue
+		}
+
+		balance, err := parseMoney(string(item.GetValue()), defaultScale)
+		if err != nil {
+			return migrated, fmt.Errorf("Cannot migrate asset: %s with error: %s", item.GetKey(), err)
+		}
+		acc, err := newAccount(stub, item.GetKey(), balance, defaultCurrency)
+		if err != nil {
+			return migrated, err
+		}
+		if err := putAccount(stub, item.GetKey(), acc); err != nil {
+			return migrated, err
+		}
+		log.Info(fmt.Sprintf("Migrated asset: %s; Balance: %s", item.GetKey(), balance))
+		migrated++
+	}
+
+	return migrated, nil
+}
+
+// the owning bank arranges an overdraft for an account
+// args[0] represents the full account
+// args[1] represents the credit limit, 0 removes the overdraft
+func setCreditLimit(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	acc, err := getAccount(stub, args[0])
+	if err != nil {
+		return "", err
+	}
+	limit, err := parseMoney(args[1], acc.Balance.Scale)
+	if err != nil {
+		return "", fmt.Errorf("Invalid credit limit! With Error: %s", err)
+	}
+	// an account already overdrawn keeps at least its overdrawn amount as the limit
+	if acc.Balance.Sign() < 0 && limit.Cmp(acc.Balance.Neg()) < 0 {
+		return "", fmt.Errorf("Cannot lower the credit limit of %s below its overdrawn balance %s!", args[0], acc.Balance)
+	}
+
+	acc.CreditLimit = limit
+	if err := putAccount(stub, args[0], acc); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Set credit limit is success! Account: %s; Limit: %s", args[0], limit), nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOverdraftUpToTheCreditLimit(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "100")
+	l.open(l.citi, "bob", "0")
+
+	l.fail(l.anz, "Only a bank admin can call setcredit!", "setcredit", "alice", "50")
+	l.fail(l.anz, "is not enough", "transfer", "alice", "bob@CitiBank", "100.01")
+	l.ok(l.anzAdmin, "setcredit", "alice", "50")
+	l.ok(l.anz, "transfer", "alice", "bob@CitiBank", "140")
+	l.fail(l.anz, "is not enough", "transfer", "alice", "bob@CitiBank", "10.01")
+	if got, want := l.ok(l.anz, "get", "alice"),
+		" Account: alice@ANZBank; Currency: CNY; Status: active; Limit: 50.00; Held: 0.00; Available: 10.00; Balance: -40.00"; got != want {
+		t.Errorf("get = %q, want %q", got, want)
+	}
+
+	// the limit cannot be lowered under the debt, nor can an overdrawn account be closed
+	l.fail(l.anzAdmin, "Cannot lower the credit limit of alice@ANZBank below its overdrawn balance -40.00!", "setcredit", "alice", "30")
+	l.fail(l.anz, "Account alice@ANZBank holds -40.00 CNY, it can only be closed at a zero balance!", "delete", "alice")
+	l.ok(l.anzAdmin, "add", "alice", "40")
+	l.ok(l.anzAdmin, "setcredit", "alice", "0")
+	l.fail(l.anz, "is not enough", "transfer", "alice", "bob@CitiBank", "0.01")
+}
+
+func TestUpgradeMigratesOnlyAccounts(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "100")
+	l.open(l.citi, "bob", "0")
+	l.ok(l.anz, "transfer", "alice", "bob@CitiBank", "10")
+	paid := l.lastTx()
+	// an account of the first chaincode version, a bare balance
+	l.stub.MockTransactionStart("seed")
+	l.stub.PutState("old@ANZBank", []byte("25"))
+	l.stub.MockTransactionEnd("seed")
+
+	l.txs++
+	l.stub.Creator = l.anz.creator
+	res := l.stub.MockInit(l.lastTx(), [][]byte{[]byte("init")})
+	l.drain()
+	if res.Status != 200 || !strings.Contains(string(res.Payload), "Migrated 1 accounts and 0 history records.") {
+		t.Fatalf("init = %d %s %s, want the old account migrated alone", res.Status, res.Message, res.Payload)
+	}
+	if got := l.balance("old@ANZBank"); got != "25.00" {
+		t.Errorf("balance of old = %s, want 25.00", got)
+	}
+	// the records of the transfer are still readable
+	if got := l.ok(l.anz, "query", "out", "alice"); !strings.Contains(got, paid) {
+		t.Errorf("query = %s, want the transfer %s of alice", got, paid)
+	}
+}
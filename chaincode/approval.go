@@ -0,0 +1,403 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// payment status values, a pending payment past its expiry reads as expired
+const (
+	paymentPending  = "pending"
+	paymentExecuted = "executed"
+	paymentRejected = "rejected"
+	paymentExpired  = "expired"
+)
+
+// approvalRule is the JSON document stored under the composite key ["approval", bank, currency].
+// A transfer of at least Threshold out of an account of the bank in the currency becomes
+// a pending payment, which executes once Approvers approvers or admins of the bank other than
+// the submitter have approved it; the owner of the account or an admin may reject it instead.
+// Expiry is how long the payment waits for them, eg. "72h".
+type approvalRule struct {
+	Bank      string `json:"bank"`
+	Currency  string `json:"currency"`
+	Threshold Money  `json:"threshold"`
+	Approvers int    `json:"approvers"`
+	Expiry    string `json:"expiry"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+// paymentApproval is one sign-off of a pending payment.
+type paymentApproval struct {
+	By   string `json:"by"`
+	Time string `json:"time"`
+}
+
+// payment is the JSON document stored under the composite key ["payment", account, id],
+// a transfer waiting for its approvals. The id is the txID of the transfer that submitted it.
+// The funds, the limits and the fee are only checked when the last approval executes it.
+type payment struct {
+	ID        string            `json:"id"`
+	Debit     string            `json:"debit"`
+	Credit    string            `json:"credit"`
+	Amount    Money             `json:"amount"`
+	Currency  string            `json:"currency"`
+	Submitter string            `json:"submitter"`
+	Required  int               `json:"required"`
+	Approvals []paymentApproval `json:"approvals"`
+	Status    string            `json:"status"`
+	Expiry    string            `json:"expiry"`
+	CreatedAt string            `json:"createdAt"`
+	ClosedAt  string            `json:"closedAt,omitempty"`
+	ClosedBy  string            `json:"closedBy,omitempty"` // txID of the execution or rejection
+	Reason    string            `json:"reason,omitempty"`
+}
+
+// expired reports whether a pending payment has run out of time at the given RFC3339 UTC time.
+func (p *payment) expired(now string) bool {
+	return p.Status == paymentPending && now >= p.Expiry
+}
+
+// readApprovalRule reads the approval rule of a bank in a currency, it returns nil if there is none.
+func readApprovalRule(stub shim.ChaincodeStubInterface, bank, currency string) (*approvalRule, error) {
+	key, err := stub.CreateCompositeKey("approval", []string{bank, currency})
+	if err != nil {
+		return nil, fmt.Errorf("Create approval key failed! With error: %s", err)
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get approval rule: %s with error: %s", bank, err)
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	rule := new(approvalRule)
+	if err := json.Unmarshal(value, rule); err != nil {
+		return nil, fmt.Errorf("Corrupted approval rule: %s with error: %s", bank, err)
+	}
+	return rule, nil
+}
+
+// getPayment reads a payment of an account.
+func getPayment(stub shim.ChaincodeStubInterface, account, id string) (*payment, error) {
+	key, err := stub.CreateCompositeKey("payment", []string{account, id})
+	if err != nil {
+		return nil, fmt.Errorf("Create payment key failed! With error: %s", err)
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get payment: %s with error: %s", id, err)
+	}
+	if value == nil {
+		return nil, fmt.Errorf("Payment not found: %s on account %s", id, account)
+	}
+
+	p := new(payment)
+	if err := json.Unmarshal(value, p); err != nil {
+		return nil, fmt.Errorf("Corrupted payment: %s with error: %s", id, err)
+	}
+	return p, nil
+}
+
+// putPayment writes a payment.
+func putPayment(stub shim.ChaincodeStubInterface, p *payment) error {
+	key, err := stub.CreateCompositeKey("payment", []string{p.Debit, p.ID})
+	if err != nil {
+		return fmt.Errorf("Create payment key failed! With error: %s", err)
+	}
+	value, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("Encode payment failed! With error: %s", err)
+	}
+	if err := stub.PutState(key, value); err != nil {
+		return fmt.Errorf("Store payment failed! With error: %s", err)
+	}
+	return nil
+}
+
+// openPayment reads a payment that can still be approved or rejected.
+func openPayment(stub shim.ChaincodeStubInterface, account, id string) (*payment, time.Time, error) {
+	tm, err := txTime(stub)
+	if err != nil {
+		return nil, tm, err
+	}
+	p, err := getPayment(stub, account, id)
+	if err != nil {
+		return nil, tm, err
+	}
+	if p.Status != paymentPending {
+		return nil, tm, fmt.Errorf("Payment %s is already %s!", p.ID, p.Status)
+	}
+	if p.expired(tm.Format(time.RFC3339)) {
+		return nil, tm, fmt.Errorf("Payment %s has expired at %s!", p.ID, p.Expiry)
+	}
+	return p, tm, nil
+}
+
+// submitPayment turns a transfer that reaches the approval threshold of the debit bank
+// into a pending payment. It returns false, and does nothing, when the transfer needs no approval.
+func submitPayment(stub shim.ChaincodeStubInterface, debitAccount, creditAccount, value, submitter string) (string, bool, error) {
+	debit, err := getAccount(stub, debitAccount)
+	if err != nil {
+		return "", false, fmt.Errorf("Reduce debit account failed! With error: %s", err)
+	}
+	rule, err := readApprovalRule(stub, bankOf(debitAccount), debit.Currency)
+	if err != nil || rule == nil {
+		return "", false, err
+	}
+	amount, err := parseAmount(value, debit.Balance.Scale)
+	if err != nil {
+		return "", false, fmt.Errorf("Invalid amount! With Error: %s", err)
+	}
+	if amount.Rat().Cmp(rule.Threshold.Rat()) < 0 {
+		return "", false, nil
+	}
+
+	if debitAccount == creditAccount {
+		return "", false, fmt.Errorf("Cannot transfer to the same account: %s", debitAccount)
+	}
+	if err := checkUsable(debitAccount, debit); err != nil {
+		return "", false, err
+	}
+	if _, err := getAccount(stub, creditAccount); err != nil {
+		return "", false, fmt.Errorf("Add credit account failed! With error: %s", err)
+	}
+	tm, err := txTime(stub)
+	if err != nil {
+		return "", false, err
+	}
+	d, err := time.ParseDuration(rule.Expiry)
+	if err != nil {
+		return "", false, fmt.Errorf("Corrupted approval rule: %s with error: %s", rule.Bank, err)
+	}
+
+	p := &payment{
+		ID:        stub.GetTxID(),
+		Debit:     debitAccount,
+		Credit:    creditAccount,
+		Amount:    amount,
+		Currency:  debit.Currency,
+		Submitter: submitter,
+		Required:  rule.Approvers,
+		Approvals: []paymentApproval{},
+		Status:    paymentPending,
+		Expiry:    tm.Add(d).Format(time.RFC3339),
+		CreatedAt: tm.Format(time.RFC3339),
+	}
+	if err := putPayment(stub, p); err != nil {
+		return "", false, err
+	}
+	return fmt.Sprintf("Transfer is pending approval! Payment: %s; Approvals: 0/%d; Expiry: %s",
+		p.ID, p.Required, p.Expiry), true, nil
+}
+
+// checkThreshold refuses to debit an amount that reaches the approval threshold of the bank
+// of the account, for the ways of moving money that do not wait for approvals, eg. a batch transfer.
+// what names the way in the error, eg. "Batch transfer".
+func checkThreshold(stub shim.ChaincodeStubInterface, account string, amount Money, what string) error {
+	acc, err := getAccount(stub, account)
+	if err != nil {
+		return err
+	}
+	rule, err := readApprovalRule(stub, bankOf(account), acc.Currency)
+	if err != nil || rule == nil {
+		return err
+	}
+	if amount.Rat().Cmp(rule.Threshold.Rat()) >= 0 {
+		return fmt.Errorf("%s of %s %s reaches the approval threshold %s %s of %s, make it a transfer to be approved!",
+			what, amount, acc.Currency, rule.Threshold, acc.Currency, bankOf(account))
+	}
+	return nil
+}
+
+// a bank admin sets the amount above which the transfers in a currency need approvals
+// args[0] represents the bank
+// args[1] represents the currency
+// args[2] represents the threshold, "*" removes the rule
+// args[3] represents the number of approvers besides the submitter
+// args[4] represents how long a payment waits for its approvals, eg. "72h"
+func setApproval(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	key, err := stub.CreateCompositeKey("approval", []string{args[0], args[1]})
+	if err != nil {
+		return "", fmt.Errorf("Create approval key failed! With error: %s", err)
+	}
+	if args[2] == anyBound {
+		if err := stub.DelState(key); err != nil {
+			return "", fmt.Errorf("Remove approval rule failed! With error: %s", err)
+		}
+		return fmt.Sprintf("Set approval is success! Bank: %s; Currency: %s; No approval needed", args[0], args[1]), nil
+	}
+
+	scale, err := currencyScale(args[1])
+	if err != nil {
+		return "", err
+	}
+	threshold, err := parseAmount(args[2], scale)
+	if err != nil {
+		return "", fmt.Errorf("Invalid threshold! With Error: %s", err)
+	}
+	approvers, err := strconv.Atoi(args[3])
+	if err != nil || approvers < 1 {
+		return "", fmt.Errorf("Invalid number of approvers: %s, expecting at least 1", args[3])
+	}
+	if d, err := time.ParseDuration(args[4]); err != nil || d <= 0 {
+		return "", fmt.Errorf("Invalid expiry: %s, expecting a duration such as 72h", args[4])
+	}
+	tm, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+
+	value, err := json.Marshal(approvalRule{
+		Bank:      args[0],
+		Currency:  args[1],
+		Threshold: threshold,
+		Approvers: approvers,
+		Expiry:    args[4],
+		UpdatedAt: tm.Format(time.RFC3339),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Encode approval rule failed! With error: %s", err)
+	}
+	if err := stub.PutState(key, value); err != nil {
+		return "", fmt.Errorf("Store approval rule failed! With error: %s", err)
+	}
+	return fmt.Sprintf("Set approval is success! Bank: %s; Threshold: %s %s; Approvers: %d; Expiry: %s",
+		args[0], threshold, args[1], approvers, args[4]), nil
+}
+
+// list the approval rules of a bank
+// args[0] represents the bank
+func approvals(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	it, err := stub.GetStateByPartialCompositeKey("approval", []string{args[0]})
+	if err != nil {
+		return "", fmt.Errorf("Cannot get by partial composite key! With error: %s", err)
+	}
+	defer it.Close()
+
+	list := []approvalRule{}
+	for it.HasNext() {
+		item, err := it.Next()
+		if err != nil {
+			return "", fmt.Errorf("Get next of iterator failed! With error: %s", err)
+		}
+		var rule approvalRule
+		if err := json.Unmarshal(item.GetValue(), &rule); err != nil {
+			return "", fmt.Errorf("Decode approval rule failed! With error: %s", err)
+		}
+		list = append(list, rule)
+	}
+
+	result, err := json.Marshal(list)
+	if err != nil {
+		return "", fmt.Errorf("Encode approval rules failed! With error: %s", err)
+	}
+	return string(result), nil
+}
+
+// approve a pending payment, the last approval required executes the transfer
+// args[0] represents the full debit account
+// args[1] represents the payment id
+// args[2] represents the identity of the approver
+func approve(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	p, tm, err := openPayment(stub, args[0], args[1])
+	if err != nil {
+		return "", err
+	}
+	if args[2] == p.Submitter {
+		return "", fmt.Errorf("The submitter cannot approve payment %s!", p.ID)
+	}
+	for _, a := range p.Approvals {
+		if a.By == args[2] {
+			return "", fmt.Errorf("You have already approved payment %s!", p.ID)
+		}
+	}
+	p.Approvals = append(p.Approvals, paymentApproval{By: args[2], Time: tm.Format(time.RFC3339)})
+
+	if len(p.Approvals) < p.Required {
+		if err := putPayment(stub, p); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Approve is success! Payment: %s; Approvals: %d/%d", p.ID, len(p.Approvals), p.Required), nil
+	}
+
+	// a transfer that cannot be made now fails the approval, which can be given again later
+	moved, err := moveFunds(stub, p.Debit, p.Credit, p.Amount.String(), nil, nil)
+	if err != nil {
+		return "", err
+	}
+	p.Status = paymentExecuted
+	p.ClosedAt = tm.Format(time.RFC3339)
+	p.ClosedBy = stub.GetTxID()
+	if err := putPayment(stub, p); err != nil {
+		return "", err
+	}
+	if err := emitEvent(stub, "transfer", moved.legs()...); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Approve is success! Payment: %s; Approvals: %d/%d; Transfer is success!%s",
+		p.ID, len(p.Approvals), p.Required, moved), nil
+}
+
+// reject a pending payment, no money is moved
+// args[0] represents the full debit account
+// args[1] represents the payment id
+// args[2] represents the reason
+func reject(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	p, tm, err := openPayment(stub, args[0], args[1])
+	if err != nil {
+		return "", err
+	}
+
+	p.Status = paymentRejected
+	p.ClosedAt = tm.Format(time.RFC3339)
+	p.ClosedBy = stub.GetTxID()
+	p.Reason = args[2]
+	if err := putPayment(stub, p); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Reject is success! Payment: %s; Reason: %s", p.ID, p.Reason), nil
+}
+
+// list the payments of an account, from the oldest to the newest txID
+// args[0] represents the full account
+func payments(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	tm, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+	now := tm.Format(time.RFC3339)
+
+	list := []payment{}
+	it, err := stub.GetStateByPartialCompositeKey("payment", []string{args[0]})
+	if err != nil {
+		return "", fmt.Errorf("Cannot get by partial composite key! With error: %s", err)
+	}
+	defer it.Close()
+	for it.HasNext() {
+		item, err := it.Next()
+		if err != nil {
+			return "", fmt.Errorf("Get next of iterator failed! With error: %s", err)
+		}
+		var p payment
+		if err := json.Unmarshal(item.GetValue(), &p); err != nil {
+			return "", fmt.Errorf("Decode payment failed! With error: %s", err)
+		}
+		// the expiry is not written back, it shows when the payment is read
+		if p.expired(now) {
+			p.Status = paymentExpired
+		}
+		list = append(list, p)
+	}
+
+	result, err := json.Marshal(list)
+	if err != nil {
+		return "", fmt.Errorf("Encode payments failed! With error: %s", err)
+	}
+	return string(result), nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApprovalThresholdOnEveryDebit(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "1000")
+	l.open(l.citi, "bob", "0")
+	l.open(l.anz, "carol", "0")
+
+	// placed before the bank asked for approvals above 100
+	l.ok(l.anz, "hold", "alice", "bob@CitiBank", "150", "72h")
+	hold := l.lastTx()
+	l.ok(l.anz, "remit", "alice", "bob@CitiBank", "150", "72h")
+	remittance := l.lastTx()
+	l.ok(l.anz, "order", "alice", "carol@ANZBank", "150", "daily", "*")
+	l.ok(l.anzAdmin, "setapproval", "CNY", "100", "1", "72h")
+
+	t.Run("batch transfer", func(t *testing.T) {
+		l.fail(l.anz, "Batch transfer of 120.00 CNY reaches the approval threshold 100.00 CNY of ANZBank",
+			"batchtransfer", "alice", `[{"credit":"bob@CitiBank","amount":"60"},{"credit":"carol@ANZBank","amount":"60"}]`)
+		l.ok(l.anz, "batchtransfer", "alice", `[{"credit":"bob@CitiBank","amount":"40"},{"credit":"carol@ANZBank","amount":"50"}]`)
+	})
+	t.Run("hold and capture", func(t *testing.T) {
+		l.fail(l.anz, "Hold of 100.00 CNY reaches the approval threshold", "hold", "alice", "bob@CitiBank", "100", "72h")
+		l.fail(l.citi, "Capture of 150.00 CNY reaches the approval threshold", "capture", "alice@ANZBank", hold)
+		l.ok(l.citi, "capture", "alice@ANZBank", hold, "99")
+	})
+	t.Run("remittance", func(t *testing.T) {
+		l.fail(l.anz, "Remittance of 100.00 CNY reaches the approval threshold", "remit", "alice", "bob@CitiBank", "100", "72h")
+		l.fail(l.citi, "Remittance of 150.00 CNY reaches the approval threshold", "acceptremit", remittance)
+	})
+	t.Run("standing order", func(t *testing.T) {
+		l.fail(l.anz, "Standing order of 100.00 CNY reaches the approval threshold", "order", "alice", "carol@ANZBank", "100", "daily", "*")
+		run := l.ok(l.anz, "runorders")
+		if !strings.Contains(run, `"failed":1`) || !strings.Contains(run, "Standing order of 150.00 CNY reaches the approval threshold") {
+			t.Errorf("runorders = %s, want the order failed on the threshold", run)
+		}
+	})
+
+	// only the transfers below the threshold moved money: 40 + 50 + 99
+	if got := l.balance("alice@ANZBank"); got != "811.00" {
+		t.Errorf("balance of alice = %s, want 811.00", got)
+	}
+	if got := l.balance("carol@ANZBank"); got != "50.00" {
+		t.Errorf("balance of carol = %s, want 50.00", got)
+	}
+}
+
+func TestPaymentApprovals(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "1000")
+	l.open(l.citi, "bob", "0")
+	approver := newClient(t, "ANZBankMSP", "anz-approver", map[string]string{roleAttribute: roleApprover})
+	teller := newClient(t, "ANZBankMSP", "anz-teller", map[string]string{roleAttribute: roleTeller})
+	other := newClient(t, "ANZBankMSP", "anz-user2", nil)
+	l.fail(l.anz, "Only a bank admin can call setapproval!", "setapproval", "CNY", "100", "2", "72h")
+	l.ok(l.anzAdmin, "setapproval", "CNY", "100", "2", "72h")
+
+	// below the threshold the transfer is made at once
+	l.ok(l.anz, "transfer", "alice", "bob@CitiBank", "99")
+	l.ok(l.anz, "transfer", "alice", "bob@CitiBank", "200")
+	executed := l.lastTx()
+	if got := l.balance("alice@ANZBank"); got != "901.00" {
+		t.Errorf("balance of alice while pending = %s, want 901.00", got)
+	}
+
+	// only the staff who sign off payments approve them, and the customers of the bank cannot reject them
+	l.fail(other, "Only a bank admin or an approver can call approve!", "approve", "alice", executed)
+	l.fail(teller, "Only a bank admin or an approver can call approve!", "approve", "alice", executed)
+	l.fail(other, "You do not own the account: alice@ANZBank", "reject", "alice", executed, "not mine")
+	l.ok(approver, "approve", "alice", executed)
+	l.fail(approver, "You have already approved payment "+executed, "approve", "alice", executed)
+	if got := l.balance("bob@CitiBank"); got != "99.00" {
+		t.Errorf("balance of bob after one approval = %s, want 99.00", got)
+	}
+	l.ok(l.anzAdmin, "approve", "alice", executed)
+	l.fail(l.anzAdmin, "Payment "+executed+" is already executed!", "reject", "alice", executed, "too late")
+	if got := l.balance("bob@CitiBank"); got != "299.00" {
+		t.Errorf("balance of bob after two approvals = %s, want 299.00", got)
+	}
+
+	// a payment past its expiry cannot be approved any more, nor by the admin who submitted it
+	l.ok(l.anzAdmin, "transfer", "alice", "bob@CitiBank", "300")
+	late := l.lastTx()
+	l.fail(l.anzAdmin, "The submitter cannot approve payment "+late, "approve", "alice", late)
+	if _, err := l.call(time.Now().Add(73*time.Hour), approve, "alice@ANZBank", late, "anz-admin"); err == nil {
+		t.Errorf("approve after the expiry succeeded, want an error")
+	}
+	l.ok(l.anz, "transfer", "alice", "bob@CitiBank", "400")
+	rejected := l.lastTx()
+	l.ok(l.anz, "reject", "alice", rejected, "unknown beneficiary")
+	l.fail(l.anzAdmin, "Payment "+rejected+" is already rejected!", "approve", "alice", rejected)
+
+	var list []payment
+	if err := json.Unmarshal([]byte(l.ok(l.anz, "payments", "alice")), &list); err != nil {
+		t.Fatalf("Decode payments failed! With error: %s", err)
+	}
+	status := make(map[string]string)
+	for _, p := range list {
+		status[p.ID] = p.Status
+	}
+	if status[executed] != paymentExecuted || status[late] != paymentPending || status[rejected] != paymentRejected {
+		t.Errorf("payments = %+v, want executed, pending and rejected", list)
+	}
+	if got := l.balance("alice@ANZBank"); got != "701.00" {
+		t.Errorf("balance of alice = %s, want 701.00", got)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// maxBatchLegs bounds the number of credit accounts paid by one batch transfer
+const maxBatchLegs = 500
+
+// batchLeg is one payment of a batch transfer, the amount is in the currency of the debit account.
+type batchLeg struct {
+	Credit string `json:"credit"`
+	Amount string `json:"amount"`
+}
+
+// transfer the money from one debit account to many credit accounts at once.
+// args[0] represents the debit account
+// args[1] represents the legs, a JSON array such as [{"credit":"bob@CitiBank","amount":"10.5"}]
+// Either every leg is applied or none of them. The legs paying the same credit account
+// are added up, so that each credit account gets one history entry.
+func batchTransfer(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	var legs []batchLeg
+	if err := json.Unmarshal([]byte(args[1]), &legs); err != nil {
+		return "", fmt.Errorf("Invalid legs! With error: %s", err)
+	}
+	if len(legs) == 0 {
+		return "", fmt.Errorf("A batch transfer needs at least one leg!")
+	}
+
+	debit, err := getAccount(stub, args[0])
+	if err != nil {
+		return "", fmt.Errorf("Reduce debit account failed! With error: %s", err)
+	}
+
+	// add up the legs of each credit account, keeping the order they first appear in
+	var credits []string
+	sums := make(map[string]Money)
+	total := Money{Scale: debit.Balance.Scale}
+	for i, leg := range legs {
+		amount, err := parseAmount(leg.Amount, debit.Balance.Scale)
+		if err != nil {
+			return "", fmt.Errorf("Invalid amount of leg %d! With Error: %s", i+1, err)
+		}
+		sum, ok := sums[leg.Credit]
+		if !ok {
+			credits = append(credits, leg.Credit)
+			sum = Money{Scale: amount.Scale}
+		}
+		if sums[leg.Credit], err = sum.Add(amount); err != nil {
+			return "", fmt.Errorf("Invalid amount of leg %d! With Error: %s", i+1, err)
+		}
+		if total, err = total.Add(amount); err != nil {
+			return "", fmt.Errorf("Invalid amount of leg %d! With Error: %s", i+1, err)
+		}
+	}
+	if len(credits) > maxBatchLegs {
+		return "", fmt.Errorf("A batch transfer pays at most %d accounts, got %d!", maxBatchLegs, len(credits))
+	}
+	// a batch cannot wait for approvals, so the whole of it stays below the threshold
+	if err := checkThreshold(stub, args[0], total, "Batch transfer"); err != nil {
+		return "", err
+	}
+
+	// every leg reads the balance written by the previous one
+	buffered := newTxStub(stub)
+	var moved *movement
+	var revenue eventLeg
+	fees := Money{Scale: debit.Balance.Scale}
+	events := make([]eventLeg, 1, len(credits)+2)
+	for _, credit := range credits {
+		if moved, err = moveFunds(buffered, args[0], credit, sums[credit].String(), nil, nil); err != nil {
+			message := fmt.Sprintf("Leg to %s failed! With error: %s", credit, err)
+			// keep the status of a hit transfer limit
+			if coded, ok := err.(*codedError); ok {
+				return "", &codedError{coded.status, message}
+			}
+			return "", fmt.Errorf("%s", message)
+		}
+		events = append(events, newLeg(credit, moved.creditAcc, moved.in.Amount))
+		if moved.revenue != "" {
+			if fees, err = fees.Add(moved.fee); err != nil {
+				return "", err
+			}
+			revenue = newLeg(moved.revenue, moved.revenueAcc, fees)
+		}
+	}
+	if err := buffered.flush(); err != nil {
+		return "", fmt.Errorf("Store batch transfer failed! With error: %s", err)
+	}
+
+	// the fees of the legs are one more leg to the revenue account
+	charged, err := total.Add(fees)
+	if err != nil {
+		return "", err
+	}
+	events[0] = newLeg(args[0], moved.debitAcc, charged.Neg())
+	if !fees.IsZero() {
+		events = append(events, revenue)
+	}
+	if err := emitEvent(stub, "batchtransfer", events...); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Batch transfer is success! Legs: %d; Total: %s %s; Fees: %s %s; Remaining balance is: %s",
+		len(credits), total, debit.Currency, fees, debit.Currency, moved.debitAcc.Balance), nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestBatchTransferIsAllOrNothing(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "100")
+	l.open(l.anz, "carol", "0")
+	l.open(l.citi, "bob", "0")
+	// the daily count shows whether a failed batch left any usage behind
+	l.ok(l.anzAdmin, "setlimits", "alice", "25", "*", "3")
+
+	l.fail(l.anz, "A batch transfer needs at least one leg!", "batchtransfer", "alice", `[]`)
+	l.fail(l.anz, "Invalid amount of leg 2!", "batchtransfer", "alice", `[{"credit":"bob@CitiBank","amount":"1"},{"credit":"carol@ANZBank","amount":"-1"}]`)
+	l.fail(l.anz, "Leg to ghost@CitiBank failed!", "batchtransfer", "alice",
+		`[{"credit":"bob@CitiBank","amount":"10"},{"credit":"carol@ANZBank","amount":"5"},{"credit":"ghost@CitiBank","amount":"1"}]`)
+	if status := l.fail(l.anz, "Leg to bob@CitiBank failed!", "batchtransfer", "alice",
+		`[{"credit":"carol@ANZBank","amount":"5"},{"credit":"bob@CitiBank","amount":"26"}]`); status != statusLimitExceeded {
+		t.Errorf("status = %d, want %d", status, statusLimitExceeded)
+	}
+	for account, want := range map[string]string{"alice@ANZBank": "100.00", "bob@CitiBank": "0.00", "carol@ANZBank": "0.00"} {
+		if got := l.balance(account); got != want {
+			t.Errorf("balance of %s after the failed batches = %s, want %s", account, got, want)
+		}
+	}
+
+	// the legs of one credit account add up to one transfer, which the limits see as one
+	l.ok(l.anz, "batchtransfer", "alice", `[{"credit":"bob@CitiBank","amount":"10"},{"credit":"carol@ANZBank","amount":"5"},{"credit":"bob@CitiBank","amount":"15"}]`)
+	records := l.lookup(l.anz, l.lastTx())
+	if len(records) != 2 || records[0].Credit != "bob@CitiBank" || records[0].Amount.String() != "25.00" ||
+		records[1].Credit != "carol@ANZBank" || records[1].Amount.String() != "5.00" {
+		t.Errorf("transfers of the batch = %+v, want 25.00 to bob and 5.00 to carol", records)
+	}
+	l.ok(l.anz, "transfer", "alice", "carol@ANZBank", "1")
+	l.fail(l.anz, "alice@ANZBank has reached its daily limit of 3 transfers", "transfer", "alice", "carol@ANZBank", "1")
+	if got := l.balance("alice@ANZBank"); got != "69.00" {
+		t.Errorf("balance of alice = %s, want 69.00", got)
+	}
+}
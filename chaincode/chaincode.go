@@ -1,9 +1,9 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
-	"strconv"
 	"strings"
 	"time"
 
@@ -24,6 +24,7 @@ var (
 	format = logging.MustStringFormatter(
 		`%{color}%{time:15:04:05.000} %{shortfunc} ▶ %{level:.4s} %{id:03x}%{color:reset} %{message}`)
 	paramLength      map[string]int
+	paramOptional    map[string]int
 	paramLengthError map[string]string
 )
 
@@ -33,24 +34,138 @@ type SimpleAsset struct {
 
 func init() {
 	paramLength = make(map[string]int)
+	paramOptional = make(map[string]int)
 	paramLengthError = make(map[string]string)
 	// init the dict for parameter length check
+	paramLength["accrue"] = 0
+	paramLengthError["accrue"] = "Incorrect arguments. Expecting no arguments."
+	paramLength["activate"] = 2
+	paramLengthError["activate"] = "Incorrect arguments. Expecting an account name and a reason."
 	paramLength["add"] = 2
-	paramLengthError["add"] = "Incorrect arguments. Expecting an account name and a balance value."
+	paramOptional["add"] = 1
+	paramLengthError["add"] = "Incorrect arguments. Expecting an account name, a balance value and an optional idempotency key."
+	paramLength["acceptremit"] = 1
+	paramLengthError["acceptremit"] = "Incorrect arguments. Expecting a remittance id."
+	paramLength["approvals"] = 0
+	paramLengthError["approvals"] = "Incorrect arguments. Expecting no arguments."
+	paramLength["approve"] = 2
+	paramLengthError["approve"] = "Incorrect arguments. Expecting an account and a payment id."
+	paramLength["assignproduct"] = 2
+	paramLengthError["assignproduct"] = "Incorrect arguments. Expecting an account name and a product, or \"*\" to remove the product."
+	paramLength["chown"] = 2
+	paramLengthError["chown"] = "Incorrect arguments. Expecting an account name and the identity of the new owner."
 	paramLength["create"] = 2
-	paramLengthError["create"] = "Incorrect arguments. Expecting an unique account name and an initial balance value."
+	paramOptional["create"] = 1
+	paramLengthError["create"] = "Incorrect arguments. Expecting an unique account name, an initial balance value and an optional currency."
+	paramLength["decidedispute"] = 3
+	paramLengthError["decidedispute"] = "Incorrect arguments. Expecting a dispute id, \"uphold\" or \"reject\" and a decision."
 	paramLength["delete"] = 1
-	paramLengthError["delete"] = "Incorrect arguments. Expecting an account being deleted."
+	paramLengthError["delete"] = "Incorrect arguments. Expecting an account being closed."
+	paramLength["dormant"] = 1
+	paramLengthError["dormant"] = "Incorrect arguments. Expecting an idle time, eg. 8760h."
+	paramLength["dispute"] = 3
+	paramLengthError["dispute"] = "Incorrect arguments. Expecting a transaction id, its full credit account and a reason."
+	paramLength["disputenote"] = 2
+	paramLengthError["disputenote"] = "Incorrect arguments. Expecting a dispute id and a note."
+	paramLength["disputes"] = 0
+	paramOptional["disputes"] = 1
+	paramLengthError["disputes"] = "Incorrect arguments. Expecting an optional status, or \"*\" for every dispute."
+	paramLength["fees"] = 0
+	paramOptional["fees"] = 1
+	paramLengthError["fees"] = "Incorrect arguments. Expecting an optional currency."
 	paramLength["get"] = 1
 	paramLengthError["get"] = "Incorrect arguments. Expecting an account name."
+	paramLength["querypage"] = 3
+	paramOptional["querypage"] = 1
+	paramLengthError["querypage"] = "Incorrect arguments. Expecting an objectType, an account, a page size and an optional bookmark."
+	paramLength["rate"] = 2
+	paramLengthError["rate"] = "Incorrect arguments. Expecting a base currency and a quote currency."
+	paramLength["batchtransfer"] = 2
+	paramLengthError["batchtransfer"] = "Incorrect arguments. Expecting a debit account and a JSON array of credit accounts and amounts."
+	paramLength["cancelorder"] = 2
+	paramLengthError["cancelorder"] = "Incorrect arguments. Expecting an account and a standing order id."
+	paramLength["capture"] = 2
+	paramOptional["capture"] = 1
+	paramLengthError["capture"] = "Incorrect arguments. Expecting a full account, a hold id and an optional amount."
+	paramLength["hold"] = 4
+	paramLengthError["hold"] = "Incorrect arguments. Expecting an account, a full beneficiary account, an amount and an expiry."
+	paramLength["holds"] = 1
+	paramLengthError["holds"] = "Incorrect arguments. Expecting an account."
+	paramLength["history"] = 2
+	paramOptional["history"] = 4
+	paramLengthError["history"] = "Incorrect arguments. Expecting a direction, an account and optional from, to, min and max filters."
+	paramLength["order"] = 5
+	paramOptional["order"] = 1
+	paramLengthError["order"] = "Incorrect arguments. Expecting an account, a full credit account, an amount, a schedule, a first run and an optional end."
+	paramLength["orders"] = 1
+	paramLengthError["orders"] = "Incorrect arguments. Expecting an account."
+	paramLength["issue"] = 3
+	paramLengthError["issue"] = "Incorrect arguments. Expecting a bank, a currency and an amount."
+	paramLength["payments"] = 1
+	paramLengthError["payments"] = "Incorrect arguments. Expecting an account."
+	paramLength["policies"] = 0
+	paramLengthError["policies"] = "Incorrect arguments. Expecting no arguments."
+	paramLength["positions"] = 0
+	paramLengthError["positions"] = "Incorrect arguments. Expecting no arguments."
+	paramLength["products"] = 0
+	paramLengthError["products"] = "Incorrect arguments. Expecting no arguments."
 	paramLength["query"] = 2
 	paramLengthError["query"] = "Incorrect arguments. Expecting an objectType and an account."
 	paramLength["reduce"] = 2
-	paramLengthError["reduce"] = "Incorrect arguments. Expecting an account name and a balance value."
+	paramOptional["reduce"] = 1
+	paramLengthError["reduce"] = "Incorrect arguments. Expecting an account name, a balance value and an optional idempotency key."
+	paramLength["rejectremit"] = 2
+	paramLengthError["rejectremit"] = "Incorrect arguments. Expecting a remittance id and a reason."
+	paramLength["remit"] = 4
+	paramLengthError["remit"] = "Incorrect arguments. Expecting an account, a full credit account at another bank, an amount and an expiry."
+	paramLength["remits"] = 0
+	paramLengthError["remits"] = "Incorrect arguments. Expecting no arguments."
+	paramLength["reject"] = 3
+	paramLengthError["reject"] = "Incorrect arguments. Expecting an account, a payment id and a reason."
+	paramLength["release"] = 2
+	paramLengthError["release"] = "Incorrect arguments. Expecting a full account and a hold id."
+	paramLength["reviewdispute"] = 1
+	paramLengthError["reviewdispute"] = "Incorrect arguments. Expecting a dispute id."
 	paramLength["rollback"] = 3
 	paramLengthError["rollback"] = "Incorrect arguments. Expecting a debit account, credit account and a transaction id."
+	paramLength["runorders"] = 0
+	paramLengthError["runorders"] = "Incorrect arguments. Expecting no arguments."
+	paramLength["settle"] = 0
+	paramLengthError["settle"] = "Incorrect arguments. Expecting no arguments."
+	paramLength["settlements"] = 0
+	paramLengthError["settlements"] = "Incorrect arguments. Expecting no arguments."
+	paramLength["setpolicy"] = 2
+	paramLengthError["setpolicy"] = "Incorrect arguments. Expecting a function and comma separated roles, or \"*\" to remove the policy."
+	paramLength["setproduct"] = 3
+	paramLengthError["setproduct"] = "Incorrect arguments. Expecting a product name, an annual rate and a day count convention."
+	paramLength["setrate"] = 3
+	paramLengthError["setrate"] = "Incorrect arguments. Expecting a base currency, a quote currency and a rate."
+	paramLength["freeze"] = 2
+	paramLengthError["freeze"] = "Incorrect arguments. Expecting a full account and a reason."
+	paramLength["setapproval"] = 4
+	paramLengthError["setapproval"] = "Incorrect arguments. Expecting a currency, a threshold or \"*\" to remove the rule, a number of approvers and an expiry."
+	paramLength["sweepremits"] = 0
+	paramLengthError["sweepremits"] = "Incorrect arguments. Expecting no arguments."
+	paramLength["setcredit"] = 2
+	paramLengthError["setcredit"] = "Incorrect arguments. Expecting an account name and a credit limit."
+	paramLength["setfees"] = 2
+	paramOptional["setfees"] = 1
+	paramLengthError["setfees"] = "Incorrect arguments. Expecting \"intra\" or \"cross\", a JSON array of fee tiers, or \"*\" to remove the fees, and an optional currency."
+	paramLength["setlimits"] = 4
+	paramOptional["setlimits"] = 1
+	paramLengthError["setlimits"] = "Incorrect arguments. Expecting an account or \"*\" for the bank, a per transaction limit, a daily amount limit, a daily count limit and an optional currency."
+	paramLength["statuslog"] = 1
+	paramLengthError["statuslog"] = "Incorrect arguments. Expecting an account."
+	paramLength["supply"] = 0
+	paramOptional["supply"] = 1
+	paramLengthError["supply"] = "Incorrect arguments. Expecting an optional currency."
+	paramLength["unfreeze"] = 2
+	paramLengthError["unfreeze"] = "Incorrect arguments. Expecting a full account and a reason."
 	paramLength["transfer"] = 3
-	paramLengthError["transfer"] = "Incorrect arguments. Expecting a debit account, a credit account and a value"
+	paramOptional["transfer"] = 1
+	paramLengthError["transfer"] = "Incorrect arguments. Expecting a debit account, a credit account, a value and an optional idempotency key."
+	paramLength["tx"] = 1
+	paramLengthError["tx"] = "Incorrect arguments. Expecting a transaction id."
 }
 
 // Init is called during chaincode instantiation to initialize any data.
@@ -79,12 +194,23 @@ func (t *SimpleAsset) Init(stub shim.ChaincodeStubInterface) peer.Response {
 		return shim.Error(fmt.Sprintf("Get client MSPID failed! With error: %s", err))
 	}
 
-	if mspid == "ANZBankMSP" {
-		return shim.Success([]byte(fmt.Sprintf("Success to initialize!")))
-	} else {
+	if mspid != "ANZBankMSP" {
 		return shim.Error("You do not have authority to get access to this function!")
 	}
 
+	// upgrade accounts and history left by older versions of the chaincode
+	accounts, err := migrateAccounts(stub)
+	if err != nil {
+		log.Error(err.Error())
+		return shim.Error(err.Error())
+	}
+	records, err := migrateHistory(stub)
+	if err != nil {
+		log.Error(err.Error())
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success([]byte(fmt.Sprintf("Success to initialize! Migrated %d accounts and %d history records.", accounts, records)))
 }
 
 // Invoke is called per transaction on the chaincode. Each transaction is
@@ -94,10 +220,10 @@ func (t *SimpleAsset) Invoke(stub shim.ChaincodeStubInterface) peer.Response {
 	// Extract the function and args from the transaction proposal
 	fn, args := stub.GetFunctionAndParameters()
 
-	// check the param length
+	// check the param length, trailing optional params may be omitted
 	if expectedLen, ok := paramLength[fn]; !ok {
 		return shim.Error("Undefined function")
-	} else if expectedLen != len(args) {
+	} else if len(args) < expectedLen || len(args) > expectedLen+paramOptional[fn] {
 		errStr, _ := paramLengthError[fn]
 		return shim.Error(errStr)
 	}
@@ -115,38 +241,232 @@ func (t *SimpleAsset) Invoke(stub shim.ChaincodeStubInterface) peer.Response {
 	if err != nil {
 		return shim.Error(fmt.Sprintf("Get client MSPID failed! With error: %s", err))
 	}
+	// get the identity of the one who calls the chaincode, which owns the accounts it creates
+	id, err := client.GetID()
+	if err != nil {
+		return shim.Error(fmt.Sprintf("Get client ID failed! With error: %s", err))
+	}
+	// the roles allowed to call a function are kept in the policy table on the ledger
+	if err := checkPolicy(stub, client, fn); err != nil {
+		return shim.Error(err.Error())
+	}
 
 	if mspid == "SuperviMSP" {
 		// pass the params ASIS
 		switch fn {
 		case "query":
 			result, err = query(stub, args)
+		case "querypage":
+			result, err = queryPage(stub, args)
+		case "history":
+			result, err = history(stub, args)
 		case "rollback":
 			result, err = rollback(stub, args)
+		case "setrate":
+			result, err = setRate(stub, args)
+		case "rate":
+			result, err = getRate(stub, args)
+		case "tx":
+			result, err = tx(stub, args)
+		case "freeze":
+			result, err = freeze(stub, []string{args[0], args[1], id, mspid})
+		case "unfreeze":
+			result, err = unfreeze(stub, []string{args[0], args[1], id, mspid})
+		case "statuslog":
+			result, err = statusLog(stub, args)
+		case "holds":
+			result, err = holds(stub, args)
+		case "orders":
+			result, err = orders(stub, args)
+		case "issue":
+			result, err = issue(stub, []string{args[0], args[1], args[2], id})
+		case "supply":
+			result, err = supply(stub, args)
+		case "payments":
+			result, err = payments(stub, args)
+		case "remits":
+			result, err = remits(stub, args)
+		case "disputenote":
+			result, err = addDisputeNote(stub, []string{args[0], args[1], supervisorParty, id})
+		case "reviewdispute":
+			result, err = reviewDispute(stub, args)
+		case "decidedispute":
+			result, err = decideDispute(stub, []string{args[0], args[1], args[2], id})
+		case "disputes":
+			result, err = disputes(stub, args)
+		case "sweepremits":
+			result, err = sweepRemits(stub, args)
+		case "positions":
+			result, err = positions(stub, args)
+		case "settle":
+			result, err = settle(stub, []string{id})
+		case "settlements":
+			result, err = settlements(stub, args)
+		case "setpolicy":
+			result, err = setPolicy(stub, []string{args[0], args[1], id})
+		case "policies":
+			result, err = policies(stub, args)
 		default:
 			return shim.Error("You do not have authority to get access to this function!")
 		}
 	} else {
 		// add orgs to input
+		bank := mspid[:len(mspid)-3] // remove "MSP"
 		fullAccount := func(account string) string {
-			return account + "@" + mspid[:len(mspid)-3] // remove "MSP"
+			return account + "@" + bank
+		}
+
+		// only the owner of an account, or a bank admin, may change it
+		admin := client.AssertAttributeValue(roleAttribute, roleBankAdmin) == nil
+		switch fn {
+		case "reduce", "delete", "transfer", "batchtransfer", "chown", "hold", "order", "cancelorder", "remit", "reject":
+			if err := checkOwner(stub, fullAccount(args[0]), id, admin); err != nil {
+				return shim.Error(err.Error())
+			}
+		case "add":
+			// a deposit brings cash into the bank, only the staff who take it in may pay it out of the reserve
+			if !admin && client.AssertAttributeValue(roleAttribute, roleTeller) != nil {
+				return shim.Error("Only a bank admin or a teller can call add!")
+			}
+		case "create":
+			// an initial balance is a deposit as with add
+			if balance, err := parseDecimal(args[1]); err == nil && balance.Sign() != 0 &&
+				!admin && client.AssertAttributeValue(roleAttribute, roleTeller) != nil {
+				return shim.Error("Only a bank admin or a teller can create an account with an initial balance!")
+			}
+		case "approve":
+			// the payments of the bank are signed off by its staff, not by its customers
+			if !admin && client.AssertAttributeValue(roleAttribute, roleApprover) != nil {
+				return shim.Error("Only a bank admin or an approver can call approve!")
+			}
+		case "capture", "release":
+			// the hold is named by the full account, the beneficiary may be at another bank
+			if err := checkHoldParty(stub, fn, args[0], args[1], bank, id, admin); err != nil {
+				return shim.Error(err.Error())
+			}
+		case "setcredit", "setlimits", "setproduct", "assignproduct", "setfees", "setapproval", "activate", "dormant":
+			if !admin {
+				return shim.Error(fmt.Sprintf("Only a bank admin can call %s!", fn))
+			}
 		}
 
 		switch fn {
 		case "get":
 			result, err = get(stub, []string{fullAccount(args[0])})
 		case "add":
-			result, err = add(stub, []string{fullAccount(args[0]), args[1]})
+			result, err = idempotent(stub, bank, id, fn, args, paramLength[fn], func() (string, error) {
+				return add(stub, []string{fullAccount(args[0]), args[1]})
+			})
 		case "reduce":
-			result, err = reduce(stub, []string{fullAccount(args[0]), args[1]})
+			result, err = idempotent(stub, bank, id, fn, args, paramLength[fn], func() (string, error) {
+				return reduce(stub, []string{fullAccount(args[0]), args[1]})
+			})
 		case "create":
-			result, err = create(stub, []string{fullAccount(args[0]), args[1]})
+			result, err = create(stub, append([]string{fullAccount(args[0])}, args[1:]...))
 		case "delete":
-			result, err = delete(stub, []string{fullAccount(args[0])})
+			result, err = delete(stub, []string{fullAccount(args[0]), id, mspid})
 		case "transfer":
-			result, err = transfer(stub, []string{fullAccount(args[0]), args[1], args[2]})
+			result, err = idempotent(stub, bank, id, fn, args, paramLength[fn], func() (string, error) {
+				return transfer(stub, []string{fullAccount(args[0]), args[1], args[2], id})
+			})
+		case "batchtransfer":
+			result, err = batchTransfer(stub, []string{fullAccount(args[0]), args[1]})
 		case "query":
 			result, err = query(stub, []string{args[0], fullAccount(args[1])})
+		case "querypage":
+			result, err = queryPage(stub, append([]string{args[0], fullAccount(args[1])}, args[2:]...))
+		case "history":
+			result, err = history(stub, append([]string{args[0], fullAccount(args[1])}, args[2:]...))
+		case "rate":
+			result, err = getRate(stub, args)
+		case "tx":
+			// a bank only sees the transfers its accounts take part in
+			result, err = tx(stub, []string{args[0], bank})
+		case "statuslog":
+			result, err = statusLog(stub, []string{fullAccount(args[0])})
+		case "policies":
+			result, err = policies(stub, args)
+		case "hold":
+			result, err = placeHold(stub, append([]string{fullAccount(args[0])}, args[1:]...))
+		case "capture":
+			result, err = capture(stub, args)
+		case "release":
+			result, err = release(stub, args)
+		case "holds":
+			result, err = holds(stub, []string{fullAccount(args[0])})
+		case "order":
+			result, err = createOrder(stub, append([]string{fullAccount(args[0])}, args[1:]...))
+		case "cancelorder":
+			result, err = cancelOrder(stub, []string{fullAccount(args[0]), args[1]})
+		case "orders":
+			result, err = orders(stub, []string{fullAccount(args[0])})
+		case "approve":
+			// any approver or admin of the debit bank but the submitter may approve
+			result, err = approve(stub, []string{fullAccount(args[0]), args[1], id})
+		case "reject":
+			result, err = reject(stub, []string{fullAccount(args[0]), args[1], args[2]})
+		case "payments":
+			result, err = payments(stub, []string{fullAccount(args[0])})
+		case "remit":
+			result, err = remit(stub, append([]string{fullAccount(args[0])}, args[1:]...))
+		case "acceptremit":
+			result, err = acceptRemit(stub, []string{args[0], bank})
+		case "rejectremit":
+			result, err = rejectRemit(stub, []string{args[0], args[1], bank})
+		case "remits":
+			result, err = remits(stub, []string{bank})
+		case "sweepremits":
+			// any bank may close the expired remittances of every bank
+			result, err = sweepRemits(stub, args)
+		case "dispute":
+			result, err = openDispute(stub, []string{args[0], args[1], args[2], bank})
+		case "disputenote":
+			result, err = addDisputeNote(stub, []string{args[0], args[1], bank, id})
+		case "disputes":
+			// a bank lists the disputes of the transfers its accounts take part in
+			status := ""
+			if len(args) > 0 {
+				status = args[0]
+			}
+			result, err = disputes(stub, []string{status, bank})
+		case "activate":
+			result, err = activate(stub, []string{fullAccount(args[0]), args[1], id, mspid})
+		case "dormant":
+			result, err = markDormant(stub, []string{bank, args[0], id, mspid})
+		case "setapproval":
+			result, err = setApproval(stub, append([]string{bank}, args...))
+		case "approvals":
+			result, err = approvals(stub, []string{bank})
+		case "runorders":
+			// any bank may run the due standing orders of every bank
+			result, err = runOrders(stub, args)
+		case "chown":
+			result, err = chown(stub, []string{fullAccount(args[0]), args[1]})
+		case "setcredit":
+			result, err = setCreditLimit(stub, []string{fullAccount(args[0]), args[1]})
+		case "setlimits":
+			// "*" sets the limits of the bank itself, in a currency
+			scope := bank
+			if args[0] != anyBound {
+				scope = fullAccount(args[0])
+			}
+			result, err = setLimits(stub, append([]string{scope}, args[1:]...))
+		case "setfees":
+			result, err = setFees(stub, append([]string{bank}, args...))
+		case "fees":
+			result, err = fees(stub, append([]string{bank}, args...))
+		case "positions":
+			result, err = positions(stub, []string{bank})
+		case "settlements":
+			result, err = settlements(stub, []string{bank})
+		case "setproduct":
+			result, err = setProduct(stub, append([]string{bank}, args...))
+		case "products":
+			result, err = products(stub, []string{bank})
+		case "assignproduct":
+			result, err = assignProduct(stub, []string{fullAccount(args[0]), args[1]})
+		case "accrue":
+			result, err = accrue(stub, []string{bank})
 		default:
 			return shim.Error("You do not have authority to get access to this function!")
 		}
@@ -154,6 +474,10 @@ func (t *SimpleAsset) Invoke(stub shim.ChaincodeStubInterface) peer.Response {
 
 	if err != nil {
 		log.Error(err.Error())
+		// some failures are answered with their own status, eg. a hit transfer limit
+		if coded, ok := err.(*codedError); ok {
+			return peer.Response{Status: coded.status, Message: coded.message}
+		}
 		return shim.Error(err.Error())
 	}
 
@@ -165,80 +489,110 @@ func (t *SimpleAsset) Invoke(stub shim.ChaincodeStubInterface) peer.Response {
 // When we need to query the remaining balance, we use this function.
 func get(stub shim.ChaincodeStubInterface, args []string) (string, error) {
 	// get the account information from the database.
-	value, err := stub.GetState(args[0])
+	acc, err := getAccount(stub, args[0])
 	if err != nil {
-		return "", fmt.Errorf("Failed to get asset: %s with error: %s", args[0], err)
+		return "", err
 	}
-	if value == nil {
-		return "", fmt.Errorf("Asset not found: %s", args[0])
+
+	// the balance is the ledger balance, the available funds also count the overdraft and the holds
+	held, err := heldAmount(stub, args[0], acc)
+	if err != nil {
+		return "", err
+	}
+	available, err := availableFunds(stub, args[0], acc)
+	if err != nil {
+		return "", err
 	}
 
-	return fmt.Sprintf(" Account: %s; Balance: %s", args[0], string(value)), nil
+	return fmt.Sprintf(" Account: %s; Currency: %s; Status: %s; Limit: %s; Held: %s; Available: %s; Balance: %s",
+		args[0], acc.Currency, acc.Status, acc.CreditLimit, held, available, acc.Balance), nil
 }
 
 // args[0] represents account, args[1] represents money.
 // Add specific number of money to the specific account.
+// The deposit is paid out of the reserve of the bank, which the supervisor funds.
 func add(stub shim.ChaincodeStubInterface, args []string) (string, error) {
-	valueTemp, err := stub.GetState(args[0])
+	acc, err := getAccount(stub, args[0])
 	if err != nil {
-		return "", fmt.Errorf("Failed to get asset: %s with error: %s", args[0], err)
+		return "", err
 	}
 
-	intArgs1, err := strconv.Atoi(args[1])
+	amount, err := parseAmount(args[1], acc.Balance.Scale)
 	if err != nil {
-		return "", fmt.Errorf("Atoi fail! With Error: %s", err)
+		return "", fmt.Errorf("Invalid amount! With Error: %s", err)
 	}
 
-	intValueTemp, err := strconv.Atoi(string(valueTemp))
+	reserve, reserveAcc, err := settleReserve(stub, args[0], acc, amount, historyDeposit)
 	if err != nil {
-		return "", fmt.Errorf("Atoi fail! With Error: %s", err)
+		return "", fmt.Errorf("Failed to add to asset: %s with error: %s", args[0], err)
 	}
 
-	err = stub.PutState(args[0], []byte(strconv.Itoa(intArgs1+intValueTemp)))
+	err = putAccount(stub, args[0], acc)
+	if err != nil {
+		return "", err
+	}
+	err = emitEvent(stub, "add", newLeg(args[0], acc, amount), newLeg(reserve, reserveAcc, amount.Neg()))
 	if err != nil {
-		return "", fmt.Errorf("Failed to set asset: %s with error: %s", args[0], err)
+		return "", err
 	}
 
-	return fmt.Sprintf("Add is success! Account: %s; Remaining balance is: %d", args[0], intValueTemp+intArgs1), nil
+	return fmt.Sprintf("Add is success! Account: %s; Remaining balance is: %s", args[0], acc.Balance), nil
 
 }
 
 // args[0] represents account, args[1] represents money.
 // Reduce specific number of money to the specific account.
+// The withdrawal goes back into the reserve of the bank.
 func reduce(stub shim.ChaincodeStubInterface, args []string) (string, error) {
 	// Get the account from the worldstate database.
-	valueTemp, err := stub.GetState(args[0])
+	acc, err := getAccount(stub, args[0])
 	if err != nil {
-		return "", fmt.Errorf("Failed to get asset: %s with error: %s", args[0], err)
+		return "", err
 	}
-	// change the argument into integer.
-	intArgs1, err := strconv.Atoi(args[1])
-	if err != nil {
-		return "", fmt.Errorf("Atoi fail! With Error: %s", err)
+	if err := checkUsable(args[0], acc); err != nil {
+		return "", err
 	}
-	//
-	intValueTemp, err := strconv.Atoi(string(valueTemp))
+	// change the argument into an amount of the account currency.
+	amount, err := parseAmount(args[1], acc.Balance.Scale)
 	if err != nil {
-		return "", fmt.Errorf("Atoi fail! With Error: %s", err)
+		return "", fmt.Errorf("Invalid amount! With Error: %s", err)
 	}
 
-	if intArgs1 > intValueTemp {
+	// the balance may go negative down to the credit limit, the holds are kept
+	available, err := availableFunds(stub, args[0], acc)
+	if err != nil {
+		return "", err
+	}
+	if amount.Cmp(available) > 0 {
 		return "", fmt.Errorf("The balance in %s's account is not enough to reduce!", args[0])
 	}
 
-	err = stub.PutState(args[0], []byte(strconv.Itoa(intValueTemp-intArgs1)))
+	reserve, reserveAcc, err := settleReserve(stub, args[0], acc, amount.Neg(), historyWithdrawal)
 	if err != nil {
-		return "", fmt.Errorf("Failed to set asset: %s;  With Error: %s", args[0], err)
+		return "", fmt.Errorf("Failed to reduce asset: %s with error: %s", args[0], err)
 	}
 
-	return fmt.Sprintf("Reduce is success! Account: %s; Remaining balance is: %d", args[0], intValueTemp-intArgs1), nil
+	err = putAccount(stub, args[0], acc)
+	if err != nil {
+		return "", err
+	}
+	err = emitEvent(stub, "reduce", newLeg(args[0], acc, amount.Neg()), newLeg(reserve, reserveAcc, amount))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("Reduce is success! Account: %s; Remaining balance is: %s", args[0], acc.Balance), nil
 
 }
 
 // The function of this module is to create an account of ledger
 // args[0] means the account ID
 // args[1] means the account initial value.
+// args[2] optionally means the ISO 4217 currency of the account, CNY by default.
 func create(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	if isSystemAccount(args[0]) {
+		return "", fmt.Errorf("Account names starting with %q are reserved: %s", systemPrefix, args[0])
+	}
 	var name []byte
 	name, err := stub.GetState(args[0])
 	if name != nil {
@@ -247,60 +601,284 @@ func create(stub shim.ChaincodeStubInterface, args []string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf(fmt.Sprintf("Failed to get access to asset: %s; With error: %s", args[0], err))
 	}
+	// the name of a closed account is never given to another account
+	archived, err := getArchived(stub, args[0])
+	if err != nil {
+		return "", err
+	}
+	if archived != nil {
+		return "", fmt.Errorf("The account %s was closed at %s, its name cannot be reused!", args[0], archived.UpdatedAt)
+	}
+
+	currency := defaultCurrency
+	if len(args) > 2 {
+		currency = args[2]
+	}
+	scale, err := currencyScale(currency)
+	if err != nil {
+		return "", err
+	}
+
+	// the initial balance may be zero, but never negative or malformed
+	balance, err := parseMoney(args[1], scale)
+	if err != nil {
+		return "", fmt.Errorf("Invalid initial balance! With Error: %s", err)
+	}
+
+	// the account is pending until money first comes in, eg. the initial balance
+	acc, err := newAccount(stub, args[0], Money{Scale: scale}, currency)
+	if err != nil {
+		return "", err
+	}
+	acc.Status = statusPending
+	// the client who creates the account owns it
+	acc.OwnerID, err = cid.GetID(stub)
+	if err != nil {
+		return "", fmt.Errorf("Get client ID failed! With error: %s", err)
+	}
+	// the initial balance is a deposit out of the reserve of the bank
+	legs := make([]eventLeg, 1, 2)
+	if !balance.IsZero() {
+		reserve, reserveAcc, err := settleReserve(stub, args[0], acc, balance, historyDeposit)
+		if err != nil {
+			return "", fmt.Errorf("Failed to create asset: %s; With Error: %s", args[0], err)
+		}
+		legs = append(legs, newLeg(reserve, reserveAcc, balance.Neg()))
+	}
+	legs[0] = newLeg(args[0], acc, balance)
 
 	// Set up any variables or assets here by calling stub.PutState()
-	// We store the key and the value on the ledger
-	err = stub.PutState(args[0], []byte(args[1]))
+	// We store the key and the account document on the ledger
+	err = putAccount(stub, args[0], acc)
 	if err != nil {
-		return "", fmt.Errorf(fmt.Sprintf("Failed to create asset: %s; With Error: %s", args[0], err))
+		return "", fmt.Errorf("Failed to create asset: %s; With Error: %s", args[0], err)
+	}
+	if err := emitEvent(stub, "create", legs...); err != nil {
+		return "", err
 	}
 
 	return fmt.Sprintf("Create account: %s  is success!", args[0]), nil
 
 }
 
-// delete an account of ledger.
+// close an account of ledger, it is archived rather than removed.
 // args[0] represents the account ID.
+// args[1] represents the identity of the caller
+// args[2] represents the MSPID of the caller
+// Only an account without money, live holds, an interest product or anything else
+// still waiting to move money in or out of it can be closed;
+// the archive under ["archive", account] keeps its name from being reused.
 func delete(stub shim.ChaincodeStubInterface, args []string) (string, error) {
-	// delete the account.
-	err := stub.DelState(args[0])
+	if isSystemAccount(args[0]) {
+		return "", fmt.Errorf("Cannot delete the system account %s!", args[0])
+	}
+	acc, err := getAccount(stub, args[0])
 	if err != nil {
+		return "", err
+	}
+	// a frozen account must not be deleted
+	if acc.Status == statusFrozen {
+		return "", fmt.Errorf("Account %s is frozen!", args[0])
+	}
+	if !acc.Balance.IsZero() {
+		return "", fmt.Errorf("Account %s holds %s %s, it can only be closed at a zero balance!", args[0], acc.Balance, acc.Currency)
+	}
+	held, err := heldAmount(stub, args[0], acc)
+	if err != nil {
+		return "", err
+	}
+	if !held.IsZero() {
+		return "", fmt.Errorf("Account %s has %s held by live holds, it cannot be closed!", args[0], held)
+	}
+	if acc.Product != "" {
+		return "", fmt.Errorf("Account %s earns interest on product %s, remove the product first!", args[0], acc.Product)
+	}
+	if err := checkUnlinked(stub, args[0]); err != nil {
+		return "", err
+	}
+
+	if err := recordStatus(stub, args[0], acc, statusClosed, "closed", args[1], args[2]); err != nil {
 		return "", fmt.Errorf("Failed to delete asset: %s with error: %s", args[0], err)
 	}
+	if err := archiveAccount(stub, args[0], acc); err != nil {
+		return "", err
+	}
+	if err := emitEvent(stub, "delete", newLeg(args[0], acc, acc.Balance)); err != nil {
+		return "", err
+	}
 
-	return fmt.Sprintf("Delete is success! Account: %s", args[0]), nil
+	return fmt.Sprintf("Delete is success! Account: %s; Status: %s", args[0], acc.Status), nil
 }
 
-// args[0] represents the debit account
-// args[1] represents the credit account
-// args[2] represents the money.
 // transfer the money from the debit account to the credit account.
+// args[0] represents the debit account
+// args[1] represents the full credit account
+// args[2] represents the amount, in the currency of the debit account
+// args[3] represents the identity of the submitter
+// A transfer that reaches the approval threshold of the debit bank only becomes a pending payment.
 func transfer(stub shim.ChaincodeStubInterface, args []string) (string, error) {
-	//reduce money from the debit account.
-	var argsD []string = make([]string, 2)
-	argsD[0] = args[0]
-	argsD[1] = args[2]
-	_, err := reduce(stub, argsD)
+	if result, pending, err := submitPayment(stub, args[0], args[1], args[2], args[3]); err != nil || pending {
+		return result, err
+	}
+	moved, err := moveFunds(stub, args[0], args[1], args[2], nil, nil)
+	if err != nil {
+		return "", err
+	}
+	if err := emitEvent(stub, "transfer", moved.legs()...); err != nil {
+		return "", err
+	}
+
+	return "Transfer is success!" + moved.String(), nil
+}
+
+// movement is a transfer done by moveFunds.
+// revenue is the account the fee went to, it is empty for a free transfer.
+type movement struct {
+	debit, credit, revenue          string
+	debitAcc, creditAcc, revenueAcc *Account
+	out, in                         historyRecord
+	fee                             Money
+}
+
+// legs describes the movement for its chaincode event, the fee is a leg of its own.
+func (m *movement) legs() []eventLeg {
+	legs := []eventLeg{newLeg(m.debit, m.debitAcc, m.out.Amount.Neg()), newLeg(m.credit, m.creditAcc, m.in.Amount)}
+	if m.revenue != "" {
+		legs = append(legs, newLeg(m.debit, m.debitAcc, m.fee.Neg()), newLeg(m.revenue, m.revenueAcc, m.fee))
+	}
+	return legs
+}
+
+// String details a movement across currencies and its fee, it is empty otherwise.
+func (m *movement) String() string {
+	details := ""
+	if m.out.Rate != "" {
+		details = fmt.Sprintf(" Debited: %s %s; Credited: %s %s; Rate: %s",
+			m.out.Amount, m.out.Currency, m.in.Amount, m.in.Currency, m.out.Rate)
+	}
+	if m.revenue != "" {
+		if details != "" {
+			details += ";"
+		}
+		details += fmt.Sprintf(" Fee: %s %s", m.fee, m.out.Currency)
+	}
+	return details
+}
+
+// moveFunds moves an amount, given in the currency of the debit account, to the credit account.
+// It checks both accounts, the available funds and the transfer limits, then writes
+// the balances, the history records and the txID index. The caller sets the event.
+// released is the amount of a hold spent by the transfer, which is available to it
+// though it is still held in the ledger; it is nil for a plain transfer.
+// agreed is the fee fixed when the transfer was set up, eg. when a remittance was sent;
+// it is nil to charge the fee of the current schedule.
+func moveFunds(stub shim.ChaincodeStubInterface, debitAccount, creditAccount, value string, released, agreed *Money) (*movement, error) {
+	if debitAccount == creditAccount {
+		return nil, fmt.Errorf("Cannot transfer to the same account: %s", debitAccount)
+	}
+	// the reserves and the revenue accounts only move by issues, deposits, withdrawals and fees
+	if isSystemAccount(debitAccount) {
+		return nil, fmt.Errorf("Cannot transfer from the system account: %s", debitAccount)
+	}
+	if isSystemAccount(creditAccount) {
+		return nil, fmt.Errorf("Cannot transfer to the system account: %s", creditAccount)
+	}
+	debit, err := getAccount(stub, debitAccount)
 	if err != nil {
-		return "", fmt.Errorf(fmt.Sprintf("Reduce debit account failed!"))
+		return nil, fmt.Errorf("Reduce debit account failed! With error: %s", err)
+	}
+	credit, err := getAccount(stub, creditAccount)
+	if err != nil {
+		return nil, fmt.Errorf("Add credit account failed! With error: %s", err)
+	}
+	// only an active account sends transfers, and a frozen one receives none
+	if err := checkUsable(debitAccount, debit); err != nil {
+		return nil, err
+	}
+	if err := checkCreditable(creditAccount, credit); err != nil {
+		return nil, err
 	}
 
+	// the amount is given in the currency of the debit account
+	amount, err := parseAmount(value, debit.Balance.Scale)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid amount! With Error: %s", err)
+	}
+	available, err := availableFunds(stub, debitAccount, debit)
+	if err == nil && released != nil {
+		available, err = available.Add(*released)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Reduce debit account failed! With error: %s", err)
+	}
+	// the fee of the debit bank is taken on top of the amount
+	var fee Money
+	if agreed != nil {
+		fee = *agreed
+	} else if fee, err = transferFee(stub, debitAccount, creditAccount, debit, amount); err != nil {
+		return nil, fmt.Errorf("Work out the transfer fee failed! With error: %s", err)
+	}
+	total, err := amount.Add(fee)
+	if err != nil {
+		return nil, fmt.Errorf("Reduce debit account failed! With error: %s", err)
+	}
+	if total.Cmp(available) > 0 {
+		return nil, fmt.Errorf("Reduce debit account failed! With error: The balance in %s's account is not enough to reduce!", debitAccount)
+	}
+	// the velocity limits are checked on the day of the transaction timestamp
+	if err := chargeLimits(stub, debitAccount, amount, debit.Currency); err != nil {
+		return nil, err
+	}
+
+	// convert at the published rate when the currencies differ
+	out := historyRecord{Amount: amount, Currency: debit.Currency}
+	in := out
+	if debit.Currency != credit.Currency {
+		converted, rate, err := convert(stub, amount, debit.Currency, credit.Currency)
+		if err != nil {
+			return nil, fmt.Errorf("Currency conversion failed! With error: %s", err)
+		}
+		in = historyRecord{Amount: converted, Currency: credit.Currency}
+		out.Rate = rate.Base + "/" + rate.Quote + " " + rate.Rate
+		in.Rate = out.Rate
+	}
+
+	if !fee.IsZero() {
+		out.Fee = &fee
+	}
+
+	//reduce money from the debit account.
+	debit.Balance, err = debit.Balance.Sub(total)
+	if err != nil {
+		return nil, fmt.Errorf("Reduce debit account failed! With error: %s", err)
+	}
 	//add money to the cebit account.
-	var argsC []string = make([]string, 2)
-	argsC[0] = args[1]
-	argsC[1] = args[2]
-	_, err = add(stub, argsC)
+	credit.Balance, err = credit.Balance.Add(in.Amount)
 	if err != nil {
-		return "", fmt.Errorf(fmt.Sprintf("Add credit account failed!"))
+		return nil, fmt.Errorf("Add credit account failed! With error: %s", err)
+	}
+	if err := touch(stub, debitAccount, debit, false); err != nil {
+		return nil, err
 	}
+	if err := touch(stub, creditAccount, credit, true); err != nil {
+		return nil, err
+	}
+	if err = putAccount(stub, debitAccount, debit); err != nil {
+		return nil, fmt.Errorf("Reduce debit account failed! With error: %s", err)
+	}
+	if err = putAccount(stub, creditAccount, credit); err != nil {
+		return nil, fmt.Errorf("Add credit account failed! With error: %s", err)
+	}
+
 	// store the transfer record into the database
 	// "out" means the money go out from one's account,
 	// so the organization of the key-value pair is:
 	// Key is a composite key, its sequence is ["out"debit account] [credit account] [uuid] [time]
 	// value is the amount of money been transfered.
-	msg, err := createHistoryKey(stub, args, "out")
+	parties := []string{debitAccount, creditAccount}
+	msg, err := createHistoryKey(stub, parties, "out", out)
 	if err != nil {
-		return "", fmt.Errorf("Create history records failed! with error: %s", err)
+		return nil, fmt.Errorf("Create history records failed! with error: %s", err)
 	}
 	log.Info(msg)
 	// store the transfer record into the database
@@ -308,63 +886,103 @@ func transfer(stub shim.ChaincodeStubInterface, args []string) (string, error) {
 	// so the organization of the key-value pair is:
 	// Key is a composite key, its sequence is ["in"credit account] [debit account] [uuid] [time]
 	// value is the amount of money been transfered.
-	msg, err = createHistoryKey(stub, args, "in")
+	msg, err = createHistoryKey(stub, parties, "in", in)
 	if err != nil {
-		return "", fmt.Errorf("Create history records failed! with error: %s", err)
+		return nil, fmt.Errorf("Create history records failed! with error: %s", err)
 	}
 	log.Info(msg)
+	// index the transfer by txID, so that it can be looked up without knowing the parties
+	if err := indexTransfer(stub, debitAccount, creditAccount, out, in); err != nil {
+		return nil, fmt.Errorf("Index transfer failed! With error: %s", err)
+	}
+	// a cross-bank transfer leaves the debit bank owing the credit bank
+	if err := recordPosition(stub, debitAccount, creditAccount, in.Amount, in.Currency); err != nil {
+		return nil, err
+	}
+
+	moved := &movement{
+		debit: debitAccount, credit: creditAccount,
+		debitAcc: debit, creditAcc: credit,
+		out: out, in: in, fee: fee,
+	}
+	if !fee.IsZero() {
+		if moved.revenue, moved.revenueAcc, err = creditFee(stub, debitAccount, debit, fee); err != nil {
+			return nil, fmt.Errorf("Credit the transfer fee failed! With error: %s", err)
+		}
+	}
+	return moved, nil
+}
+
+// recordReversed is the status of a history record that has been rolled back
+const recordReversed = "reversed"
 
-	return fmt.Sprintf("Transfer is success!"), nil
+// historyRecord is the value of an "in" or "out" history key.
+// Counterpart is the other account of the transfer.
+// Amount is given in the currency of the account the key belongs to,
+// and Rate is the FX rate applied when the two accounts differ in currency.
+// A rolled back record is kept with Status "reversed" and ReversedBy set to the
+// txID of the rollback, whose compensating records carry ReversalOf.
+// Type is empty for a transfer, other entries such as "interest" name their kind.
+// Fee is the fee the debit account paid on top of the amount of a transfer.
+type historyRecord struct {
+	Type        string `json:"type,omitempty"`
+	Counterpart string `json:"counterpart"`
+	Amount      Money  `json:"amount"`
+	Currency    string `json:"currency"`
+	Rate        string `json:"rate,omitempty"`
+	Fee         *Money `json:"fee,omitempty"`
+	Status      string `json:"status,omitempty"`
+	ReversedBy  string `json:"reversedBy,omitempty"`
+	ReversalOf  string `json:"reversalOf,omitempty"`
+}
+
+// decodeHistory decodes the value of a history key.
+// Older versions of the chaincode stored the bare amount instead of a record.
+func decodeHistory(value []byte) (historyRecord, error) {
+	var record historyRecord
+	if strings.HasPrefix(string(value), "{") {
+		err := json.Unmarshal(value, &record)
+		return record, err
+	}
+
+	amount, err := parseMoney(string(value), defaultScale)
+	return historyRecord{Amount: amount, Currency: defaultCurrency}, err
 }
 
 // create history transferring records
 // "out" means the money go out from one's account,
 // "in" means the money go into one's account,
 // both "out" and "in" is tags, they emphasize on going out or in records
-func createHistoryKey(stub shim.ChaincodeStubInterface, args []string, first string) (string, error) {
+// args[0] represents the debit account and args[1] the credit account.
+func createHistoryKey(stub shim.ChaincodeStubInterface, args []string, first string, record historyRecord) (string, error) {
 	// get the time of the transaction been finished.
-	FormatTime, err := stub.GetTxTimestamp()
+	tm, err := txTime(stub)
 	if err != nil {
-		return "", fmt.Errorf(fmt.Sprintf("Get transaction timestamp failed!"))
+		return "", err
 	}
-	tm := time.Unix(FormatTime.Seconds, 0)
 
-	// if we need to create an "out" record
 	// the organization of the key-value pair is:
-	// Key is a composite key, its sequence is ["out"debit account] [credit account] [uuid] [time]
-	// value is the amount of money been transfered.
-	if first == "out" {
-		historyKey, err := stub.CreateCompositeKey(first, []string{
-			args[0], "->", args[1],
-			"\t", stub.GetTxID(),
-			"\t", tm.Format("Mon Jan 2 15:04:05 +0800 UTC 2006"),
-		})
-		if err != nil {
-			return "", fmt.Errorf("Create historyKey failed! With error: %s", err)
-		}
-
-		err = stub.PutState(historyKey, []byte(args[2]))
-		if err != nil {
-			return "", fmt.Errorf("Store transfer information failed! With error: %s", err)
-		}
+	// Key is a composite key, its sequence is ["out"debit account] [time] [uuid] [credit account]
+	// or ["in"credit account] [time] [uuid] [debit account].
+	// value is the history record of the money been transfered.
+	// The RFC3339 UTC time sorts chronologically, so the records of an account
+	// are iterated from the oldest to the newest.
+	account, counterpart := args[0], args[1]
+	if first == "in" {
+		account, counterpart = args[1], args[0]
+	}
+	record.Counterpart = counterpart
 
-	} else if first == "in" {
-		// so the organization of the key-value pair is:
-		// Key is a composite key, its sequence is ["in"credit account] [debit account] [uuid] [time]
-		// value is the amount of money been transfered.
-		historyKey, err := stub.CreateCompositeKey(first, []string{
-			args[1], "<-", args[0],
-			"\t", stub.GetTxID(),
-			"\t", tm.Format("Mon Jan 2 15:04:05 +0800 UTC 2006"),
-		})
-		if err != nil {
-			return "", fmt.Errorf("Create historyKey failed! With error: %s", err)
-		}
+	historyKey, err := stub.CreateCompositeKey(first, []string{
+		account, tm.Format(time.RFC3339), stub.GetTxID(), counterpart,
+	})
+	if err != nil {
+		return "", fmt.Errorf("Create historyKey failed! With error: %s", err)
+	}
 
-		err = stub.PutState(historyKey, []byte(args[2]))
-		if err != nil {
-			return "", fmt.Errorf("Store transfer information failed! With error: %s", err)
-		}
+	err = putHistory(stub, historyKey, record)
+	if err != nil {
+		return "", fmt.Errorf("Store transfer information failed! With error: %s", err)
 	}
 
 	return fmt.Sprintf("Insert records success!"), nil
@@ -373,8 +991,8 @@ func createHistoryKey(stub shim.ChaincodeStubInterface, args []string, first str
 // query for the transferring history.
 // args[0] represents the objectType, that is, "in" or "out"
 // the variable "objectType" will store with the first argument of the composite key as one string.
-// for example, if we store "Yongmao", "Songyue", "1", "10:01:10" with objectType "in",
-// actually the string will be: inYongmao Songyue 1 10:01:10,
+// for example, if we store "Yongmao", "2019-07-01T10:01:10Z", "1", "Songyue" with objectType "in",
+// actually the string will be: inYongmao 2019-07-01T10:01:10Z 1 Songyue,
 // every space is the seperator of each string.
 // args[1] represents the account name
 func query(stub shim.ChaincodeStubInterface, args []string) (string, error) {
@@ -400,7 +1018,11 @@ func query(stub shim.ChaincodeStubInterface, args []string) (string, error) {
 			return "", fmt.Errorf(fmt.Sprintf("Get next of iterator failed!"))
 		}
 		log.Info(fmt.Sprintf("%s %s", item.GetKey(), item.GetValue()))
-		result = result + fmt.Sprintf("%s\t%s\n", item.GetKey()[(len(args[0])+1):], item.GetValue()) // omit "in" / "out"
+		entry, err := decodeHistoryEntry(stub, item.GetKey(), item.GetValue())
+		if err != nil {
+			return "", err
+		}
+		result = result + entry.String() + "\n"
 	}
 
 	if result == "" {
@@ -414,90 +1036,161 @@ func query(stub shim.ChaincodeStubInterface, args []string) (string, error) {
 // args[0] represents debit account in transferring record
 // args[1] represents credit account in transferring record
 // args[2] represents transaction id in transferring record
+// The original records are kept and marked as reversed, and the money flows
+// back through a compensating transfer that links to the original txID,
+// so the audit trail is never erased. The fee of the transfer is not refunded,
+// the bank may pay it back from its revenue account.
 func rollback(stub shim.ChaincodeStubInterface, args []string) (string, error) {
 	// get satisfied out record
-	var PCKeyOut []string = make([]string, 1)
-	PCKeyOut[0] = args[0]
-	itOut, err := stub.GetStateByPartialCompositeKey("out", PCKeyOut)
+	outKey, recordOut, err := findHistory(stub, "out", args[0], args[1], args[2])
 	if err != nil {
-		return "", fmt.Errorf(fmt.Sprintf("Cannot get by partial composite key when get \"in\" record!"))
+		return "", err
 	}
-	//get money value and delete "out" record
-	defer itOut.Close()
-	var money []byte
-	if itOut.HasNext() == false {
-		return "", fmt.Errorf(fmt.Sprintf("Database do not have such records! Please check you arguments!"))
+	// get satisfied in record
+	inKey, recordIn, err := findHistory(stub, "in", args[1], args[0], args[2])
+	if err != nil {
+		return "", err
 	}
-	for itOut.HasNext() {
-		item, err := itOut.Next()
-		if err != nil {
-			return "", fmt.Errorf(fmt.Sprintf("Get next of iteratorOut failed!"))
-		}
-		log.Info(fmt.Sprintf("%s %s", item.GetKey(), item.GetValue()))
-		// get attribute from composite key
-		_, attrArray, err := stub.SplitCompositeKey(item.GetKey())
-		if err != nil {
-			return "", fmt.Errorf(fmt.Sprintf("Split composite key failed!"))
+
+	if recordOut.ReversalOf != "" {
+		return "", fmt.Errorf("Transaction %s is a reversal itself and cannot be rolled back!", args[2])
+	}
+	if recordOut.Status == recordReversed || recordIn.Status == recordReversed {
+		return "", fmt.Errorf("Transaction %s has already been reversed by transaction %s!", args[2], recordOut.ReversedBy)
+	}
+
+	// Then we should put money back into debit account.
+	// the two legs may differ in currency, so each side is reversed by its own amount
+	//reduce money from the credit account.
+	// the supervisor may roll back the transfers of a frozen account,
+	// so the credit account is debited directly instead of through reduce;
+	// what its live holds reserve for others is not available to the rollback.
+	creditAcc, err := getAccount(stub, args[1])
+	if err != nil {
+		return "", fmt.Errorf("Reduce credit account failed! With error: %s", err)
+	}
+	available, err := availableFunds(stub, args[1], creditAcc)
+	if err != nil {
+		return "", fmt.Errorf("Reduce credit account failed! With error: %s", err)
+	}
+	if recordIn.Amount.Cmp(available) > 0 {
+		return "", fmt.Errorf("Reduce credit account failed! With error: The balance in %s's account is not enough to reduce!", args[1])
+	}
+	if creditAcc.Balance, err = creditAcc.Balance.Sub(recordIn.Amount); err != nil {
+		return "", fmt.Errorf("Reduce credit account failed! With error: %s", err)
+	}
+	if err = putAccount(stub, args[1], creditAcc); err != nil {
+		return "", fmt.Errorf("Reduce credit account failed! With error: %s", err)
+	}
+
+	//add money to the debit account.
+	debitAcc, err := getAccount(stub, args[0])
+	if err != nil {
+		return "", fmt.Errorf("Add debit account failed! With error: %s", err)
+	}
+	if debitAcc.Balance, err = debitAcc.Balance.Add(recordOut.Amount); err != nil {
+		return "", fmt.Errorf("Add debit account failed! With error: %s", err)
+	}
+	if err = putAccount(stub, args[0], debitAcc); err != nil {
+		return "", fmt.Errorf("Add debit account failed! With error: %s", err)
+	}
+
+	// mark the original records as reversed
+	for key, record := range map[string]historyRecord{outKey: recordOut, inKey: recordIn} {
+		record.Status = recordReversed
+		record.ReversedBy = stub.GetTxID()
+		if err := putHistory(stub, key, record); err != nil {
+			return "", err
 		}
-		// compare the input hash code with the hash code stored in database
-		IsThisOne := strings.Compare(attrArray[4], args[2])
-		if IsThisOne == 0 {
-			money = item.GetValue()
-			stub.DelState(item.GetKey())
-			break
+	}
+
+	// the compensating transfer runs from the credit account back to the debit account
+	reversalArgs := []string{args[1], args[0]}
+	reversalOut := historyRecord{
+		Amount: recordIn.Amount, Currency: recordIn.Currency, Rate: recordIn.Rate, ReversalOf: args[2]}
+	reversalIn := historyRecord{
+		Amount: recordOut.Amount, Currency: recordOut.Currency, Rate: recordOut.Rate, ReversalOf: args[2]}
+	msg, err := createHistoryKey(stub, reversalArgs, "out", reversalOut)
+	if err != nil {
+		return "", fmt.Errorf("Create reversal records failed! with error: %s", err)
+	}
+	log.Info(msg)
+	msg, err = createHistoryKey(stub, reversalArgs, "in", reversalIn)
+	if err != nil {
+		return "", fmt.Errorf("Create reversal records failed! with error: %s", err)
+	}
+	log.Info(msg)
+
+	// keep the txID index in step, transfers made before the index have no record
+	record, err := getTransfer(stub, args[2], args[1])
+	if err != nil {
+		return "", err
+	}
+	if record != nil {
+		record.Status = recordReversed
+		record.ReversedBy = stub.GetTxID()
+		if err := putTransfer(stub, record); err != nil {
+			return "", err
 		}
 	}
-	// delete "in" record
-	var PCKeyIn []string = make([]string, 1)
-	PCKeyIn[0] = args[1]
-	itIn, err := stub.GetStateByPartialCompositeKey("in", PCKeyIn)
+	if err := indexTransfer(stub, args[1], args[0], reversalOut, reversalIn); err != nil {
+		return "", fmt.Errorf("Index reversal failed! With error: %s", err)
+	}
+	// cancel the position of the transfer exactly, in its own currency
+	if err := recordPosition(stub, args[0], args[1], recordIn.Amount.Neg(), recordIn.Currency); err != nil {
+		return "", err
+	}
+	err = emitEvent(stub, "rollback",
+		newLeg(args[1], creditAcc, recordIn.Amount.Neg()), newLeg(args[0], debitAcc, recordOut.Amount))
 	if err != nil {
-		return "", fmt.Errorf(fmt.Sprintf("Cannot get by partial composite key when get \"in\" record!"))
+		return "", err
 	}
 
-	defer itIn.Close()
-	if itIn.HasNext() == false {
-		return "", fmt.Errorf(fmt.Sprintf("Database do not have such records! Please check you arguments!"))
+	return fmt.Sprintf("rollback Success! Transaction %s is reversed by transaction %s", args[2], stub.GetTxID()), nil
+}
+
+// findHistory looks up the "in" or "out" history record of a transaction.
+// account is the account the record belongs to and counterpart the other party.
+func findHistory(stub shim.ChaincodeStubInterface, first, account, counterpart, txID string) (string, historyRecord, error) {
+	it, err := stub.GetStateByPartialCompositeKey(first, []string{account})
+	if err != nil {
+		return "", historyRecord{}, fmt.Errorf("Cannot get by partial composite key when get \"%s\" record!", first)
 	}
-	for itIn.HasNext() {
-		item, err := itIn.Next()
+	defer it.Close()
+
+	for it.HasNext() {
+		item, err := it.Next()
 		if err != nil {
-			return "", fmt.Errorf(fmt.Sprintf("Get next of iteratorIn failed!"))
+			return "", historyRecord{}, fmt.Errorf("Get next of iterator failed! With error: %s", err)
 		}
-		log.Info(fmt.Sprintf("%s %s", item.GetKey(), item.GetValue()))
 		// get attribute from composite key
 		_, attrArray, err := stub.SplitCompositeKey(item.GetKey())
 		if err != nil {
-			return "", fmt.Errorf(fmt.Sprintf("Split composite key failed!"))
+			return "", historyRecord{}, fmt.Errorf("Split composite key failed! With error: %s", err)
 		}
-		// compare the input hash code with the hash code stored in database
-		IsThisOne := strings.Compare(attrArray[4], args[2])
-		if IsThisOne == 0 {
-			stub.DelState(item.GetKey())
-			break
+		// compare the input hash code and counterpart with the ones stored in database
+		if attrArray[2] == txID && attrArray[3] == counterpart {
+			record, err := decodeHistory(item.GetValue())
+			if err != nil {
+				return "", historyRecord{}, fmt.Errorf("Decode \"%s\" record failed! With error: %s", first, err)
+			}
+			return item.GetKey(), record, nil
 		}
 	}
 
-	// Then we should put money back into debit account.
-	//reduce money from the debit account.
-	var argsD []string = make([]string, 2)
-	argsD[0] = args[1]
-	argsD[1] = string(money)
-	_, err = reduce(stub, argsD)
-	if err != nil {
-		return "", fmt.Errorf(fmt.Sprintf("Reduce debit account failed! With error: %s", err))
-	}
+	return "", historyRecord{}, fmt.Errorf("Database do not have such \"%s\" records! Please check you arguments!", first)
+}
 
-	//add money to the cebit account.
-	var argsC []string = make([]string, 2)
-	argsC[0] = args[0]
-	argsC[1] = string(money)
-	_, err = add(stub, argsC)
+// putHistory encodes a history record and writes it under an existing key.
+func putHistory(stub shim.ChaincodeStubInterface, key string, record historyRecord) error {
+	value, err := json.Marshal(record)
 	if err != nil {
-		return "", fmt.Errorf(fmt.Sprintf("Add cebit account failed! With error: %s", err))
+		return fmt.Errorf("Encode history record failed! With error: %s", err)
 	}
-
-	return fmt.Sprintf("rollback Success!"), nil
+	if err := stub.PutState(key, value); err != nil {
+		return fmt.Errorf("Store history record failed! With error: %s", err)
+	}
+	return nil
 }
 
 // main function starts up the chaincode in the container during instantiate
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/msp"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// attributesOID is the certificate extension in which fabric-ca puts the attributes of a client,
+// as the JSON document {"attrs": {...}} that cid reads.
+var attributesOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+// testClient is a client identity of one organization, which invokes the chaincode.
+type testClient struct {
+	mspid   string
+	creator []byte
+}
+
+// newClient makes a client identity with a self-signed certificate carrying the attributes.
+func newClient(t *testing.T, mspid, name string, attrs map[string]string) testClient {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Generate key failed! With error: %s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name, Organization: []string{mspid}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if attrs != nil {
+		value, err := json.Marshal(map[string]map[string]string{"attrs": attrs})
+		if err != nil {
+			t.Fatalf("Encode attributes failed! With error: %s", err)
+		}
+		template.ExtraExtensions = []pkix.Extension{{Id: attributesOID, Value: value}}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Create certificate failed! With error: %s", err)
+	}
+
+	creator, err := proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   mspid,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	})
+	if err != nil {
+		t.Fatalf("Encode identity failed! With error: %s", err)
+	}
+	return testClient{mspid: mspid, creator: creator}
+}
+
+// testLedger is the chaincode on a MockStub, with a customer and an admin of each bank and the supervisor.
+// events holds the chaincode events set by the last invocation.
+type testLedger struct {
+	t          *testing.T
+	stub       *shim.MockStub
+	txs        int
+	events     []*peer.ChaincodeEvent
+	anz        testClient
+	anzAdmin   testClient
+	citi       testClient
+	citiAdmin  testClient
+	supervisor testClient
+}
+
+// newLedger starts an empty ledger, whose bank reserves hold 1000000 of every currency.
+func newLedger(t *testing.T) *testLedger {
+	admin := map[string]string{roleAttribute: roleBankAdmin}
+	l := &testLedger{
+		t:          t,
+		stub:       shim.NewMockStub("gopenbanking", new(SimpleAsset)),
+		anz:        newClient(t, "ANZBankMSP", "anz-user1", nil),
+		anzAdmin:   newClient(t, "ANZBankMSP", "anz-admin", admin),
+		citi:       newClient(t, "CitiBankMSP", "citi-user1", nil),
+		citiAdmin:  newClient(t, "CitiBankMSP", "citi-admin", admin),
+		supervisor: newClient(t, "SuperviMSP", "supervisor", nil),
+	}
+	for _, bank := range []string{"ANZBank", "CitiBank"} {
+		for _, currency := range []string{"CNY", "USD", "JPY"} {
+			l.ok(l.supervisor, "issue", bank, currency, "1000000")
+		}
+	}
+	return l
+}
+
+// invoke runs a function of the chaincode as a client, in a transaction of its own.
+func (l *testLedger) invoke(client testClient, args ...string) peer.Response {
+	l.txs++
+	l.stub.Creator = client.creator
+	input := make([][]byte, len(args))
+	for i, arg := range args {
+		input[i] = []byte(arg)
+	}
+	defer l.drain()
+	return l.stub.MockInvoke(fmt.Sprintf("tx%03d", l.txs), input)
+}
+
+// drain moves the events set by an invocation out of the channel of the MockStub,
+// which would block the chaincode once it is full.
+func (l *testLedger) drain() {
+	l.events = nil
+	for {
+		select {
+		case e := <-l.stub.ChaincodeEventsChannel:
+			l.events = append(l.events, e)
+		default:
+			return
+		}
+	}
+}
+
+// event decodes the event of the last invocation, that is, the last one it set.
+// It returns nil if the invocation set none.
+func (l *testLedger) event() *ledgerEvent {
+	l.t.Helper()
+	if len(l.events) == 0 {
+		return nil
+	}
+	e := new(ledgerEvent)
+	if err := json.Unmarshal(l.events[len(l.events)-1].Payload, e); err != nil {
+		l.t.Fatalf("Decode event failed! With error: %s", err)
+	}
+	return e
+}
+
+// lastTx returns the transaction id of the last invocation.
+func (l *testLedger) lastTx() string {
+	return fmt.Sprintf("tx%03d", l.txs)
+}
+
+// ok invokes a function that has to succeed, and returns its payload.
+func (l *testLedger) ok(client testClient, args ...string) string {
+	l.t.Helper()
+	res := l.invoke(client, args...)
+	if res.Status != shim.OK {
+		l.t.Fatalf("%v failed with status %d: %s", args, res.Status, res.Message)
+	}
+	return string(res.Payload)
+}
+
+// fail invokes a function that has to fail with a message containing want, and returns its status.
+func (l *testLedger) fail(client testClient, want string, args ...string) int32 {
+	l.t.Helper()
+	res := l.invoke(client, args...)
+	if res.Status < shim.ERRORTHRESHOLD {
+		l.t.Fatalf("%v succeeded, expecting an error containing %q: %s", args, want, res.Payload)
+	}
+	if !strings.Contains(res.Message, want) {
+		l.t.Fatalf("%v failed with %q, expecting an error containing %q", args, res.Message, want)
+	}
+	return res.Status
+}
+
+// call runs a chaincode function directly, in a transaction of its own at the given time,
+// for the functions that depend on the time or run unattended, eg. the expiry of holds.
+func (l *testLedger) call(at time.Time, fn func(shim.ChaincodeStubInterface, []string) (string, error), args ...string) (string, error) {
+	l.txs++
+	txID := fmt.Sprintf("tx%03d", l.txs)
+	l.stub.MockTransactionStart(txID)
+	defer l.drain()
+	defer l.stub.MockTransactionEnd(txID)
+	l.stub.TxTimestamp = &timestamp.Timestamp{Seconds: at.Unix()}
+	return fn(l.stub, args)
+}
+
+// account reads an account as it is stored.
+func (l *testLedger) account(account string) *Account {
+	l.t.Helper()
+	value := l.stub.State[account]
+	if value == nil {
+		l.t.Fatalf("Account not found: %s", account)
+	}
+	acc := new(Account)
+	if err := json.Unmarshal(value, acc); err != nil {
+		l.t.Fatalf("Corrupted account: %s with error: %s", account, err)
+	}
+	return acc
+}
+
+// balance returns the balance of an account as a decimal string.
+func (l *testLedger) balance(account string) string {
+	l.t.Helper()
+	return l.account(account).Balance.String()
+}
+
+// open creates an account of a customer and deposits the amount into it.
+func (l *testLedger) open(owner testClient, name, amount string, currency ...string) {
+	l.t.Helper()
+	admin := l.anzAdmin
+	if owner.mspid == l.citiAdmin.mspid {
+		admin = l.citiAdmin
+	}
+	l.ok(owner, append([]string{"create", name, "0"}, currency...)...)
+	if amount != "0" {
+		l.ok(admin, "add", name, amount)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// rateScale is the number of decimal places accepted for FX rates
+const rateScale = 8
+
+// currencyScales maps the supported ISO 4217 currency codes to their minor units.
+var currencyScales = map[string]int{
+	"AUD": 2,
+	"CNY": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"HKD": 2,
+	"JPY": 0,
+	"NZD": 2,
+	"SGD": 2,
+	"USD": 2,
+}
+
+// fxRate is the JSON document stored under the composite key ["fx", base, quote].
+// One unit of Base is worth Rate units of Quote.
+type fxRate struct {
+	Base      string `json:"base"`
+	Quote     string `json:"quote"`
+	Rate      string `json:"rate"`
+	UpdatedAt string `json:"updatedAt"`
+	TxID      string `json:"txID"`
+}
+
+// currencyScale returns the minor units of a supported currency.
+func currencyScale(currency string) (int, error) {
+	scale, ok := currencyScales[currency]
+	if !ok {
+		return 0, fmt.Errorf("Unsupported currency: %s", currency)
+	}
+	return scale, nil
+}
+
+// parseRate strictly parses a positive FX rate such as "7.1034".
+func parseRate(s string) (*big.Rat, error) {
+	fixed, err := parseMoney(s, rateScale)
+	if err != nil {
+		return nil, err
+	}
+	if fixed.IsZero() {
+		return nil, fmt.Errorf("Rate must be greater than zero: %s", s)
+	}
+	return fixed.Rat(), nil
+}
+
+// the supervisor publishes an FX rate
+// args[0] represents the base currency
+// args[1] represents the quote currency
+// args[2] represents how many units of quote currency one unit of base currency buys
+func setRate(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	for _, currency := range args[:2] {
+		if _, err := currencyScale(currency); err != nil {
+			return "", err
+		}
+	}
+	if args[0] == args[1] {
+		return "", fmt.Errorf("Base and quote currency must differ!")
+	}
+	rate, err := parseRate(args[2])
+	if err != nil {
+		return "", fmt.Errorf("Invalid rate! With error: %s", err)
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+	record := fxRate{
+		Base:      args[0],
+		Quote:     args[1],
+		Rate:      rate.FloatString(rateScale),
+		UpdatedAt: now.Format(time.RFC3339),
+		TxID:      stub.GetTxID(),
+	}
+	value, err := json.Marshal(record)
+	if err != nil {
+		return "", fmt.Errorf("Failed to encode rate! With error: %s", err)
+	}
+
+	key, err := stub.CreateCompositeKey("fx", args[:2])
+	if err != nil {
+		return "", fmt.Errorf("Create rate key failed! With error: %s", err)
+	}
+	if err := stub.PutState(key, value); err != nil {
+		return "", fmt.Errorf("Store rate failed! With error: %s", err)
+	}
+
+	return fmt.Sprintf("Set rate is success! 1 %s = %s %s", args[0], record.Rate, args[1]), nil
+}
+
+// getRate returns the published FX rate.
+// args[0] represents the base currency
+// args[1] represents the quote currency
+func getRate(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	record, err := readRate(stub, args[0], args[1])
+	if err != nil {
+		return "", err
+	}
+	if record == nil {
+		return "", fmt.Errorf("Rate not found: %s/%s", args[0], args[1])
+	}
+
+	return fmt.Sprintf("Rate: 1 %s = %s %s; Updated at: %s", record.Base, record.Rate, record.Quote, record.UpdatedAt), nil
+}
+
+// readRate reads a published FX rate, it returns nil if the pair is not published.
+func readRate(stub shim.ChaincodeStubInterface, base, quote string) (*fxRate, error) {
+	key, err := stub.CreateCompositeKey("fx", []string{base, quote})
+	if err != nil {
+		return nil, fmt.Errorf("Create rate key failed! With error: %s", err)
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get rate: %s/%s with error: %s", base, quote, err)
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	record := new(fxRate)
+	if err := json.Unmarshal(value, record); err != nil {
+		return nil, fmt.Errorf("Corrupted rate: %s/%s with error: %s", base, quote, err)
+	}
+	return record, nil
+}
+
+// convert changes an amount of one currency into another at the published rate.
+// If only the opposite pair is published, its inverse is used.
+// It returns the converted amount and the published rate that was applied.
+func convert(stub shim.ChaincodeStubInterface, amount Money, from, to string) (Money, *fxRate, error) {
+	scale, err := currencyScale(to)
+	if err != nil {
+		return Money{}, nil, err
+	}
+
+	record, err := readRate(stub, from, to)
+	if err != nil {
+		return Money{}, nil, err
+	}
+	inverse := false
+	if record == nil {
+		if record, err = readRate(stub, to, from); err != nil {
+			return Money{}, nil, err
+		}
+		inverse = true
+	}
+	if record == nil {
+		return Money{}, nil, fmt.Errorf("No rate published between %s and %s", from, to)
+	}
+
+	rate, err := parseRate(record.Rate)
+	if err != nil {
+		return Money{}, nil, fmt.Errorf("Corrupted rate: %s/%s with error: %s", record.Base, record.Quote, err)
+	}
+	if inverse {
+		rate.Inv(rate)
+	}
+
+	converted, err := amount.MulRat(rate, scale)
+	if err != nil {
+		return Money{}, nil, err
+	}
+	if converted.IsZero() {
+		return Money{}, nil, fmt.Errorf("Amount %s %s is too small to convert into %s", amount, from, to)
+	}
+	return converted, record, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTransferAcrossCurrencies(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "100", "USD")
+	l.open(l.citi, "bob", "100")
+	l.open(l.citi, "yuki", "0", "JPY")
+
+	l.fail(l.anz, "No rate published between USD and CNY", "transfer", "alice", "bob@CitiBank", "10")
+	l.fail(l.anz, "You do not have authority to get access to this function!", "setrate", "USD", "CNY", "7.1034")
+	l.fail(l.supervisor, "Base and quote currency must differ!", "setrate", "USD", "USD", "1")
+	l.fail(l.supervisor, "Unsupported currency: XYZ", "setrate", "USD", "XYZ", "1")
+	l.fail(l.supervisor, "Invalid rate!", "setrate", "USD", "CNY", "0")
+	l.ok(l.supervisor, "setrate", "USD", "CNY", "7.1034")
+	l.ok(l.supervisor, "setrate", "USD", "JPY", "143.555")
+	if got := l.ok(l.anz, "rate", "USD", "CNY"); !strings.HasPrefix(got, "Rate: 1 USD = 7.10340000 CNY;") {
+		t.Errorf("rate = %s, want 1 USD = 7.10340000 CNY", got)
+	}
+
+	// 10.01 USD is 71.105034 CNY, rounded half away from zero to the cents of CNY
+	got := l.ok(l.anz, "transfer", "alice", "bob@CitiBank", "10.01")
+	paid := l.lastTx()
+	if want := "Debited: 10.01 USD; Credited: 71.11 CNY; Rate: USD/CNY 7.10340000"; !strings.Contains(got, want) {
+		t.Errorf("transfer = %s, want %s", got, want)
+	}
+	// the inverse of the published rate converts the other way: 100 CNY is 14.0777... USD
+	l.ok(l.citi, "transfer", "bob", "alice@ANZBank", "100")
+	// JPY has no minor units: 0.01 USD is 1.43555 JPY
+	l.ok(l.anz, "transfer", "alice", "yuki@CitiBank", "0.01")
+	for account, want := range map[string]string{"alice@ANZBank": "104.06", "bob@CitiBank": "71.11", "yuki@CitiBank": "1"} {
+		if got := l.balance(account); got != want {
+			t.Errorf("balance of %s = %s, want %s", account, got, want)
+		}
+	}
+
+	// both history records keep the rate applied, each in the currency of its account
+	if got := l.ok(l.anz, "query", "out", "alice"); !strings.Contains(got, paid+"\t") ||
+		!strings.Contains(got, "10.01 USD (USD/CNY 7.10340000)") {
+		t.Errorf("query out = %s, want 10.01 USD at the rate", got)
+	}
+	if got := l.ok(l.citi, "query", "in", "bob"); !strings.Contains(got, "71.11 CNY (USD/CNY 7.10340000)") {
+		t.Errorf("query in = %s, want 71.11 CNY at the rate", got)
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// dispute status values, a dispute is live until the supervisor upholds or rejects it
+const (
+	disputeOpen     = "open"
+	disputeReview   = "review"
+	disputeUpheld   = "upheld"
+	disputeRejected = "rejected"
+)
+
+// supervisorParty names the supervisor among the parties of a dispute
+const supervisorParty = "Supervisor"
+
+// disputeNote is a piece of evidence added to a dispute.
+type disputeNote struct {
+	Party string `json:"party"` // the bank, or the supervisor
+	By    string `json:"by"`
+	Text  string `json:"text"`
+	Time  string `json:"time"`
+}
+
+// dispute is the JSON document stored under the composite key ["dispute", id],
+// a claim of a bank against one transfer, named by its txID and credit account.
+// The id is the txID of the transaction that opened it. An upheld dispute is settled
+// by a rollback of the transfer in the same transaction, ReversedBy is its txID.
+type dispute struct {
+	ID         string        `json:"id"`
+	TxID       string        `json:"txID"`
+	Debit      string        `json:"debit"`
+	Credit     string        `json:"credit"`
+	Amount     Money         `json:"amount"`
+	Currency   string        `json:"currency"`
+	OpenedBy   string        `json:"openedBy"` // the bank that opened it
+	Reason     string        `json:"reason"`
+	Status     string        `json:"status"`
+	Notes      []disputeNote `json:"notes"`
+	Decision   string        `json:"decision,omitempty"`
+	DecidedBy  string        `json:"decidedBy,omitempty"`
+	DecidedAt  string        `json:"decidedAt,omitempty"`
+	ReversedBy string        `json:"reversedBy,omitempty"`
+	CreatedAt  string        `json:"createdAt"`
+	UpdatedAt  string        `json:"updatedAt"`
+}
+
+// live reports whether a dispute still waits for a decision.
+func (d *dispute) live() bool {
+	return d.Status == disputeOpen || d.Status == disputeReview
+}
+
+// involves reports whether a bank takes part in the disputed transfer.
+func (d *dispute) involves(bank string) bool {
+	return bankOf(d.Debit) == bank || bankOf(d.Credit) == bank
+}
+
+// getDispute reads a dispute.
+func getDispute(stub shim.ChaincodeStubInterface, id string) (*dispute, error) {
+	key, err := stub.CreateCompositeKey("dispute", []string{id})
+	if err != nil {
+		return nil, fmt.Errorf("Create dispute key failed! With error: %s", err)
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get dispute: %s with error: %s", id, err)
+	}
+	if value == nil {
+		return nil, fmt.Errorf("Dispute not found: %s", id)
+	}
+
+	d := new(dispute)
+	if err := json.Unmarshal(value, d); err != nil {
+		return nil, fmt.Errorf("Corrupted dispute: %s with error: %s", id, err)
+	}
+	return d, nil
+}
+
+// putDispute writes a dispute, stamped with the transaction time.
+func putDispute(stub shim.ChaincodeStubInterface, d *dispute) error {
+	tm, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	d.UpdatedAt = tm.Format(time.RFC3339)
+
+	key, err := stub.CreateCompositeKey("dispute", []string{d.ID})
+	if err != nil {
+		return fmt.Errorf("Create dispute key failed! With error: %s", err)
+	}
+	value, err := json.Marshal(d)
+	if err != nil {
+		return fmt.Errorf("Encode dispute failed! With error: %s", err)
+	}
+	if err := stub.PutState(key, value); err != nil {
+		return fmt.Errorf("Store dispute failed! With error: %s", err)
+	}
+	return nil
+}
+
+// listDisputes reads every dispute, from the oldest to the newest txID.
+func listDisputes(stub shim.ChaincodeStubInterface) ([]dispute, error) {
+	list := []dispute{}
+	err := scanRecords(stub, "dispute", func(value []byte) error {
+		var d dispute
+		if err := json.Unmarshal(value, &d); err != nil {
+			return fmt.Errorf("Decode dispute failed! With error: %s", err)
+		}
+		list = append(list, d)
+		return nil
+	})
+	return list, err
+}
+
+// a bank opens a dispute against a transfer its accounts take part in
+// args[0] represents the transaction id of the transfer
+// args[1] represents the full credit account of the transfer
+// args[2] represents the reason
+// args[3] represents the bank of the caller
+func openDispute(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	record, err := getTransfer(stub, args[0], args[1])
+	if err != nil {
+		return "", err
+	}
+	// do not reveal that a transaction of other banks exists
+	if record == nil || (bankOf(record.Debit) != args[3] && bankOf(record.Credit) != args[3]) {
+		return "", fmt.Errorf("Transaction not found: %s", args[0])
+	}
+	if record.ReversalOf != "" {
+		return "", fmt.Errorf("Transaction %s is a reversal itself and cannot be disputed!", args[0])
+	}
+	if record.Status != recordPosted {
+		return "", fmt.Errorf("Transaction %s has already been reversed by transaction %s!", args[0], record.ReversedBy)
+	}
+
+	list, err := listDisputes(stub)
+	if err != nil {
+		return "", err
+	}
+	for _, d := range list {
+		if d.live() && d.TxID == record.TxID && d.Credit == record.Credit {
+			return "", fmt.Errorf("Transaction %s is already disputed by dispute %s!", args[0], d.ID)
+		}
+	}
+
+	tm, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+	d := &dispute{
+		ID:        stub.GetTxID(),
+		TxID:      record.TxID,
+		Debit:     record.Debit,
+		Credit:    record.Credit,
+		Amount:    record.Amount,
+		Currency:  record.Currency,
+		OpenedBy:  args[3],
+		Reason:    args[2],
+		Status:    disputeOpen,
+		Notes:     []disputeNote{},
+		CreatedAt: tm.Format(time.RFC3339),
+	}
+	if err := putDispute(stub, d); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Open dispute is success! Dispute: %s; Transaction: %s; Debit: %s; Credit: %s",
+		d.ID, d.TxID, d.Debit, d.Credit), nil
+}
+
+// add evidence to a live dispute
+// args[0] represents the dispute id
+// args[1] represents the note
+// args[2] represents the party of the caller, a bank of the transfer or the supervisor
+// args[3] represents the identity of the caller
+func addDisputeNote(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	d, err := getDispute(stub, args[0])
+	if err != nil {
+		return "", err
+	}
+	if args[2] != supervisorParty && !d.involves(args[2]) {
+		return "", fmt.Errorf("Dispute not found: %s", args[0])
+	}
+	if !d.live() {
+		return "", fmt.Errorf("Dispute %s is already %s!", d.ID, d.Status)
+	}
+
+	tm, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+	d.Notes = append(d.Notes, disputeNote{Party: args[2], By: args[3], Text: args[1], Time: tm.Format(time.RFC3339)})
+	if err := putDispute(stub, d); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Dispute note is success! Dispute: %s; Notes: %d", d.ID, len(d.Notes)), nil
+}
+
+// the supervisor takes an open dispute under review
+// args[0] represents the dispute id
+func reviewDispute(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	d, err := getDispute(stub, args[0])
+	if err != nil {
+		return "", err
+	}
+	if d.Status != disputeOpen {
+		return "", fmt.Errorf("Dispute %s has status %s, only an open dispute can be reviewed!", d.ID, d.Status)
+	}
+
+	d.Status = disputeReview
+	if err := putDispute(stub, d); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Review dispute is success! Dispute: %s", d.ID), nil
+}
+
+// the supervisor decides a dispute under review, upholding it rolls the transfer back
+// args[0] represents the dispute id
+// args[1] represents the outcome, that is, "uphold" or "reject"
+// args[2] represents the decision
+// args[3] represents the identity of the supervisor
+func decideDispute(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	d, err := getDispute(stub, args[0])
+	if err != nil {
+		return "", err
+	}
+	if d.Status != disputeReview {
+		return "", fmt.Errorf("Dispute %s has status %s, only a dispute under review can be decided!", d.ID, d.Status)
+	}
+
+	reversal := ""
+	switch args[1] {
+	case "uphold":
+		// the reversal fails the whole decision if it cannot be made
+		if reversal, err = rollback(stub, []string{d.Debit, d.Credit, d.TxID}); err != nil {
+			return "", err
+		}
+		d.Status = disputeUpheld
+		d.ReversedBy = stub.GetTxID()
+	case "reject":
+		d.Status = disputeRejected
+	default:
+		return "", fmt.Errorf("Unknown outcome: %s, expecting uphold or reject", args[1])
+	}
+
+	tm, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+	d.Decision = args[2]
+	d.DecidedBy = args[3]
+	d.DecidedAt = tm.Format(time.RFC3339)
+	if err := putDispute(stub, d); err != nil {
+		return "", err
+	}
+	result := fmt.Sprintf("Decide dispute is success! Dispute: %s; Status: %s", d.ID, d.Status)
+	if reversal != "" {
+		result += "; " + reversal
+	}
+	return result, nil
+}
+
+// list the disputes, by default the ones waiting for a decision
+// args[0] optionally represents a status to list, "*" lists every dispute
+// args[1] optionally represents a bank, only the disputes of its transfers are listed
+func disputes(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	list, err := listDisputes(stub)
+	if err != nil {
+		return "", err
+	}
+
+	filtered := []dispute{}
+	for i := range list {
+		d := &list[i]
+		if len(args) > 1 && !d.involves(args[1]) {
+			continue
+		}
+		status := ""
+		if len(args) > 0 {
+			status = args[0]
+		}
+		if (status == "" && !d.live()) || (status != "" && status != anyBound && status != d.Status) {
+			continue
+		}
+		filtered = append(filtered, *d)
+	}
+
+	result, err := json.Marshal(filtered)
+	if err != nil {
+		return "", fmt.Errorf("Encode disputes failed! With error: %s", err)
+	}
+	return string(result), nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDisputeLifecycle(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "100")
+	l.open(l.anz, "carol", "100")
+	l.open(l.citi, "bob", "0")
+
+	l.ok(l.anz, "transfer", "alice", "bob@CitiBank", "40")
+	paid := l.lastTx()
+	l.ok(l.anz, "transfer", "carol", "alice@ANZBank", "5")
+	intra := l.lastTx()
+
+	l.fail(l.citi, "Transaction not found: "+intra, "dispute", intra, "alice@ANZBank", "not ours either")
+	l.ok(l.anz, "dispute", paid, "bob@CitiBank", "goods never arrived")
+	id := l.lastTx()
+	l.fail(l.citi, "Transaction "+paid+" is already disputed by dispute "+id+"!", "dispute", paid, "bob@CitiBank", "again")
+
+	// both banks and the supervisor add evidence, only the supervisor decides
+	l.ok(l.citi, "disputenote", id, "delivery receipt attached")
+	l.ok(l.supervisor, "disputenote", id, "asked for the tracking number")
+	l.fail(l.supervisor, "only a dispute under review can be decided", "decidedispute", id, "uphold", "too early")
+	l.fail(l.anz, "You do not have authority to get access to this function!", "reviewdispute", id)
+	l.ok(l.supervisor, "reviewdispute", id)
+	l.fail(l.supervisor, "only an open dispute can be reviewed", "reviewdispute", id)
+	l.fail(l.supervisor, "Unknown outcome: maybe, expecting uphold or reject", "decidedispute", id, "maybe", "unsure")
+	l.ok(l.supervisor, "decidedispute", id, "uphold", "receipt was forged")
+	reversal := l.lastTx()
+
+	l.fail(l.anz, "Dispute "+id+" is already upheld!", "disputenote", id, "thanks")
+	l.fail(l.citi, "Transaction "+reversal+" is a reversal itself and cannot be disputed!", "dispute", reversal, "alice@ANZBank", "no")
+	l.fail(l.anz, "Transaction "+paid+" has already been reversed by transaction "+reversal+"!", "dispute", paid, "bob@CitiBank", "again")
+
+	if got := l.ok(l.citi, "disputes"); got != "[]" {
+		t.Errorf("live disputes = %s, want none", got)
+	}
+	var list []dispute
+	if err := json.Unmarshal([]byte(l.ok(l.citi, "disputes", "*")), &list); err != nil {
+		t.Fatalf("Decode disputes failed! With error: %s", err)
+	}
+	if len(list) != 1 || list[0].Status != disputeUpheld || list[0].ReversedBy != reversal || len(list[0].Notes) != 2 ||
+		list[0].Notes[0].Party != "CitiBank" || list[0].Notes[1].Party != supervisorParty {
+		t.Errorf("disputes = %+v, want the dispute upheld with 2 notes", list)
+	}
+	if got := l.balance("alice@ANZBank"); got != "105.00" {
+		t.Errorf("balance of alice = %s, want 105.00", got)
+	}
+	if got := l.balance("bob@CitiBank"); got != "0.00" {
+		t.Errorf("balance of bob = %s, want 0.00", got)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// ledgerEvent is the payload of the chaincode event set by every balance-changing function.
+// The event name is the same as Type, eg. "transfer", so that listeners can filter on it.
+// A transaction carries only one chaincode event, so a function that calls
+// another one sets its own event last, replacing the inner one.
+type ledgerEvent struct {
+	Type string     `json:"type"`
+	TxID string     `json:"txID"`
+	Time string     `json:"time"`
+	Legs []eventLeg `json:"legs"`
+}
+
+// eventLeg is the change of one account in a ledgerEvent.
+// Amount is signed, negative when money leaves the account.
+type eventLeg struct {
+	Account  string `json:"account"`
+	Amount   Money  `json:"amount"`
+	Currency string `json:"currency"`
+	Balance  Money  `json:"balance"`
+}
+
+// newLeg describes the change of an account by an amount, after the change is applied.
+func newLeg(account string, acc *Account, amount Money) eventLeg {
+	return eventLeg{Account: account, Amount: amount, Currency: acc.Currency, Balance: acc.Balance}
+}
+
+// emitEvent sets the chaincode event of the current transaction.
+func emitEvent(stub shim.ChaincodeStubInterface, eventType string, legs ...eventLeg) error {
+	tm, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+
+	if legs == nil {
+		legs = []eventLeg{}
+	}
+	payload, err := json.Marshal(ledgerEvent{
+		Type: eventType,
+		TxID: stub.GetTxID(),
+		Time: tm.Format(time.RFC3339),
+		Legs: legs,
+	})
+	if err != nil {
+		return fmt.Errorf("Encode event failed! With error: %s", err)
+	}
+	if err := stub.SetEvent(eventType, payload); err != nil {
+		return fmt.Errorf("Set event failed! With error: %s", err)
+	}
+	return nil
+}
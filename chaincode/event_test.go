@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// String formats a leg as "account amount currency => balance".
+func (leg eventLeg) String() string {
+	return fmt.Sprintf("%s %s %s => %s", leg.Account, leg.Amount, leg.Currency, leg.Balance)
+}
+
+func TestEventsOfBalanceChanges(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "100")
+	l.open(l.citi, "bob", "0")
+
+	// the transfer is the next transaction, the rollback reverses it
+	transfer := fmt.Sprintf("tx%03d", l.txs+1)
+	for _, step := range []struct {
+		client testClient
+		args   []string
+		want   string
+	}{{
+		client: l.anz,
+		args:   []string{"transfer", "alice", "bob@CitiBank", "10"},
+		want:   "transfer [alice@ANZBank -10.00 CNY => 90.00 bob@CitiBank 10.00 CNY => 10.00]"}, {
+		client: l.supervisor,
+		args:   []string{"rollback", "alice@ANZBank", "bob@CitiBank", transfer},
+		want:   "rollback [bob@CitiBank -10.00 CNY => 0.00 alice@ANZBank 10.00 CNY => 100.00]"}, {
+		client: l.anz,
+		args:   []string{"reduce", "alice", "30"},
+		want:   "reduce [alice@ANZBank -30.00 CNY => 70.00 $reserve.CNY@ANZBank 30.00 CNY => 999930.00]"},
+	} {
+		l.ok(step.client, step.args...)
+		e := l.event()
+		if e == nil || e.TxID != l.lastTx() || fmt.Sprintf("%s %v", e.Type, e.Legs) != step.want {
+			t.Errorf("event of %v = %+v, want %s in %s", step.args, e, step.want, l.lastTx())
+		}
+		if name := l.events[len(l.events)-1].EventName; name != e.Type {
+			t.Errorf("event of %v is named %s, want %s", step.args, name, e.Type)
+		}
+	}
+
+	// reading or failing changes no balance, so it sets no event
+	l.ok(l.anz, "get", "alice")
+	if e := l.event(); e != nil {
+		t.Errorf("event of get = %+v, want none", e)
+	}
+	l.fail(l.anz, "is not enough", "transfer", "alice", "bob@CitiBank", "1000")
+	if e := l.event(); e != nil {
+		t.Errorf("event of a failed transfer = %+v, want none", e)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const (
+	// systemPrefix starts the names of the accounts kept by the chaincode itself,
+	// clients cannot create accounts named like this
+	systemPrefix = "$"
+
+	// historyFee is the type of the history entries moving a transfer fee to the bank
+	historyFee = "fee"
+)
+
+// feeTier charges Flat plus Rate times the amount on the transfers of at least From.
+// Rate is a fraction, eg. "0.001" for 0.1%. Flat and From are in the currency of the schedule.
+type feeTier struct {
+	From Money `json:"from"`
+	Flat Money `json:"flat"`
+	Rate Money `json:"rate"`
+}
+
+// feeSchedule is the JSON document stored under the composite key ["fee", bank, currency],
+// the fees the bank charges on the transfers out of its accounts in the currency.
+// Intra applies to the transfers within the bank and Cross to the other ones;
+// the tiers are sorted by From and a transfer falls into the last tier it reaches.
+type feeSchedule struct {
+	Bank      string    `json:"bank"`
+	Currency  string    `json:"currency"`
+	Intra     []feeTier `json:"intra"`
+	Cross     []feeTier `json:"cross"`
+	UpdatedAt string    `json:"updatedAt"`
+}
+
+// revenueAccount returns the account of a bank that collects the fees in a currency,
+// eg. "$revenue.CNY@ANZBank".
+func revenueAccount(bank, currency string) string {
+	return systemPrefix + "revenue." + currency + "@" + bank
+}
+
+// isSystemAccount reports whether a full account is kept by the chaincode itself.
+func isSystemAccount(account string) bool {
+	return strings.HasPrefix(account, systemPrefix)
+}
+
+// readFees reads the fee schedule of a bank in a currency, it returns an empty schedule if the bank has none.
+func readFees(stub shim.ChaincodeStubInterface, bank, currency string) (*feeSchedule, error) {
+	key, err := stub.CreateCompositeKey("fee", []string{bank, currency})
+	if err != nil {
+		return nil, fmt.Errorf("Create fee key failed! With error: %s", err)
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get fee schedule: %s %s with error: %s", bank, currency, err)
+	}
+
+	schedule := &feeSchedule{Bank: bank, Currency: currency, Intra: []feeTier{}, Cross: []feeTier{}}
+	if value == nil {
+		return schedule, nil
+	}
+	if err := json.Unmarshal(value, schedule); err != nil {
+		return nil, fmt.Errorf("Corrupted fee schedule: %s %s with error: %s", bank, currency, err)
+	}
+	return schedule, nil
+}
+
+// transferFee works out the fee of a transfer, in the currency and scale of the debit account.
+// The transfers from or to a system account, eg. out of the revenue account, are free.
+func transferFee(stub shim.ChaincodeStubInterface, debitAccount, creditAccount string, debit *Account, amount Money) (Money, error) {
+	fee := Money{Scale: debit.Balance.Scale}
+	if isSystemAccount(debitAccount) || isSystemAccount(creditAccount) {
+		return fee, nil
+	}
+	schedule, err := readFees(stub, bankOf(debitAccount), debit.Currency)
+	if err != nil {
+		return fee, err
+	}
+
+	tiers := schedule.Cross
+	if bankOf(debitAccount) == bankOf(creditAccount) {
+		tiers = schedule.Intra
+	}
+	var tier *feeTier
+	for i := range tiers {
+		if tiers[i].From.Rat().Cmp(amount.Rat()) > 0 {
+			break
+		}
+		tier = &tiers[i]
+	}
+	if tier == nil {
+		return fee, nil
+	}
+
+	exact := new(big.Rat).Mul(amount.Rat(), tier.Rate.Rat())
+	return roundRat(exact.Add(exact, tier.Flat.Rat()), fee.Scale)
+}
+
+// creditFee moves a fee, already taken out of the debit account, to the revenue account
+// of the debit bank, which is opened on its first fee. The fees that a debit account pays
+// within one transaction, eg. in a batch transfer, add up to one pair of history entries.
+// It returns the revenue account and its new state.
+func creditFee(stub shim.ChaincodeStubInterface, debitAccount string, debit *Account, fee Money) (string, *Account, error) {
+	revenue := revenueAccount(bankOf(debitAccount), debit.Currency)
+	acc, err := getSystemAccount(stub, revenue, debit.Currency)
+	if err != nil {
+		return "", nil, err
+	}
+	if acc.Balance, err = acc.Balance.Add(fee); err != nil {
+		return "", nil, fmt.Errorf("Add revenue account failed! With error: %s", err)
+	}
+	if err := putAccount(stub, revenue, acc); err != nil {
+		return "", nil, fmt.Errorf("Add revenue account failed! With error: %s", err)
+	}
+
+	record := historyRecord{Type: historyFee, Amount: fee, Currency: debit.Currency}
+	if err := addHistory(stub, "out", debitAccount, revenue, record); err != nil {
+		return "", nil, err
+	}
+	if err := addHistory(stub, "in", revenue, debitAccount, record); err != nil {
+		return "", nil, err
+	}
+	return revenue, acc, nil
+}
+
+// a bank admin sets the fees of the transfers out of the accounts of the bank in a currency
+// args[0] represents the bank
+// args[1] represents the kind of transfer, that is, "intra" within the bank or "cross" to other banks
+// args[2] represents the tiers, a JSON array such as [{"from":"0","flat":"1","rate":"0.001"}]; "*" removes the fees
+// args[3] optionally represents the currency of the tiers, CNY by default
+func setFees(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	currency := defaultCurrency
+	if len(args) > 3 {
+		currency = args[3]
+	}
+	scale, err := currencyScale(currency)
+	if err != nil {
+		return "", err
+	}
+	schedule, err := readFees(stub, args[0], currency)
+	if err != nil {
+		return "", err
+	}
+
+	tiers := []feeTier{}
+	if args[2] != anyBound {
+		if err := json.Unmarshal([]byte(args[2]), &tiers); err != nil {
+			return "", fmt.Errorf("Invalid fee tiers! With error: %s", err)
+		}
+	}
+	for i, tier := range tiers {
+		if tier.From.Sign() < 0 || tier.Flat.Sign() < 0 || tier.Rate.Sign() < 0 {
+			return "", fmt.Errorf("Fee tier %d has a negative value!", i+1)
+		}
+		if tier.From.Scale > scale || tier.Flat.Scale > scale {
+			return "", fmt.Errorf("Fee tier %d has more decimals than %s allows!", i+1, currency)
+		}
+		if i > 0 && tier.From.Rat().Cmp(tiers[i-1].From.Rat()) <= 0 {
+			return "", fmt.Errorf("Fee tiers must be sorted by increasing from amount!")
+		}
+	}
+
+	switch args[1] {
+	case "intra":
+		schedule.Intra = tiers
+	case "cross":
+		schedule.Cross = tiers
+	default:
+		return "", fmt.Errorf("Unknown kind of transfer: %s, expecting intra or cross", args[1])
+	}
+
+	tm, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+	schedule.UpdatedAt = tm.Format(time.RFC3339)
+	key, err := stub.CreateCompositeKey("fee", []string{args[0], currency})
+	if err != nil {
+		return "", fmt.Errorf("Create fee key failed! With error: %s", err)
+	}
+	value, err := json.Marshal(schedule)
+	if err != nil {
+		return "", fmt.Errorf("Encode fee schedule failed! With error: %s", err)
+	}
+	if err := stub.PutState(key, value); err != nil {
+		return "", fmt.Errorf("Store fee schedule failed! With error: %s", err)
+	}
+	return fmt.Sprintf("Set fees is success! Bank: %s; Currency: %s; Kind: %s; Tiers: %d", args[0], currency, args[1], len(tiers)), nil
+}
+
+// show the fee schedule of a bank in a currency
+// args[0] represents the bank
+// args[1] optionally represents the currency, CNY by default
+func fees(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	currency := defaultCurrency
+	if len(args) > 1 {
+		currency = args[1]
+	}
+	if _, err := currencyScale(currency); err != nil {
+		return "", err
+	}
+	schedule, err := readFees(stub, args[0], currency)
+	if err != nil {
+		return "", err
+	}
+
+	result, err := json.Marshal(schedule)
+	if err != nil {
+		return "", fmt.Errorf("Encode fee schedule failed! With error: %s", err)
+	}
+	return string(result), nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFeesPerCurrency(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "100")
+	l.open(l.anz, "dave", "100", "USD")
+	l.open(l.anz, "yuki", "1000", "JPY")
+	l.open(l.citi, "bob", "0")
+	l.open(l.citi, "erin", "0", "USD")
+	l.open(l.citi, "kenji", "0", "JPY")
+
+	l.ok(l.anzAdmin, "setfees", "cross", `[{"from":"0","flat":"1","rate":"0.01"}]`)
+	l.fail(l.anzAdmin, "Fee tier 1 has more decimals than JPY allows!", "setfees", "cross", `[{"from":"0","flat":"0.5"}]`, "JPY")
+	l.fail(l.anzAdmin, "Unsupported currency: XYZ", "setfees", "cross", `[{"from":"0","flat":"1"}]`, "XYZ")
+	l.ok(l.anzAdmin, "setfees", "cross", `[{"from":"0","flat":"5"}]`, "JPY")
+	if got := l.ok(l.anz, "fees", "JPY"); !strings.Contains(got, `"currency":"JPY"`) || !strings.Contains(got, `"flat":"5"`) {
+		t.Errorf("fees JPY = %s, want the JPY tiers", got)
+	}
+
+	// the CNY tiers charge 1 + 1% on CNY, the JPY tiers 5 yen, and USD has none
+	l.ok(l.anz, "transfer", "alice", "bob@CitiBank", "10")
+	if got := l.ok(l.anz, "tx", l.lastTx()); !strings.Contains(got, `"fee":"1.10"`) {
+		t.Errorf("tx = %s, want a fee of 1.10", got)
+	}
+	l.ok(l.anz, "transfer", "yuki", "kenji@CitiBank", "100")
+	l.ok(l.anz, "transfer", "dave", "erin@CitiBank", "10")
+	if got := l.ok(l.anz, "tx", l.lastTx()); strings.Contains(got, `"fee"`) {
+		t.Errorf("tx = %s, want no fee", got)
+	}
+
+	for account, want := range map[string]string{
+		"alice@ANZBank":                  "88.90",
+		"yuki@ANZBank":                   "895",
+		"dave@ANZBank":                   "90.00",
+		revenueAccount("ANZBank", "CNY"): "1.10",
+		revenueAccount("ANZBank", "JPY"): "5",
+	} {
+		if got := l.balance(account); got != want {
+			t.Errorf("balance of %s = %s, want %s", account, got, want)
+		}
+	}
+}
+
+func TestFeeTiers(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "5000")
+	l.open(l.anz, "carol", "0")
+	l.open(l.citi, "bob", "0")
+
+	// a transfer within the bank falls into the last tier it reaches,
+	// the cross-bank ones have no tiers and are free
+	l.ok(l.anzAdmin, "setfees", "intra", `[{"from":"0","flat":"0.5"},{"from":"100","rate":"0.001"},{"from":"1000","flat":"2"}]`)
+	for _, c := range []struct{ amount, fee string }{
+		{"99.99", "0.50"},
+		{"100", "0.10"},
+		{"999.99", "1.00"},
+		{"1000", "2.00"},
+	} {
+		l.ok(l.anz, "transfer", "alice", "carol@ANZBank", c.amount)
+		if got := l.ok(l.anz, "tx", l.lastTx()); !strings.Contains(got, `"fee":"`+c.fee+`"`) {
+			t.Errorf("tx of %s = %s, want a fee of %s", c.amount, got, c.fee)
+		}
+	}
+	l.ok(l.anz, "transfer", "alice", "bob@CitiBank", "1000")
+	if got := l.ok(l.anz, "tx", l.lastTx()); strings.Contains(got, `"fee"`) {
+		t.Errorf("tx = %s, want no fee", got)
+	}
+
+	for account, want := range map[string]string{
+		"alice@ANZBank":                  "1796.42",
+		"carol@ANZBank":                  "2199.98",
+		revenueAccount("ANZBank", "CNY"): "3.60",
+	} {
+		if got := l.balance(account); got != want {
+			t.Errorf("balance of %s = %s, want %s", account, got, want)
+		}
+	}
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const (
+	// maxPageSize bounds the number of history entries returned in one page
+	maxPageSize = 1000
+	// legacyTimeFormat is how older versions of the chaincode put the time into history keys
+	legacyTimeFormat = "Mon Jan 2 15:04:05 +0800 UTC 2006"
+	// anyBound is the placeholder for an unused filter of the history function
+	anyBound = "*"
+)
+
+// historyEntry is a decoded history key and its record.
+type historyEntry struct {
+	Direction string `json:"direction"`
+	Account   string `json:"account"`
+	Time      string `json:"time"`
+	TxID      string `json:"txID"`
+	historyRecord
+}
+
+// historyPage is the JSON payload returned by queryPage and history.
+// Bookmark is empty when there are no more entries.
+type historyPage struct {
+	Entries  []historyEntry `json:"entries"`
+	Count    int32          `json:"count"`
+	Bookmark string         `json:"bookmark"`
+}
+
+// decodeHistoryEntry splits a history key and decodes its record.
+// the key sequence is ["in" / "out"] [account] [time] [uuid] [counterpart]
+func decodeHistoryEntry(stub shim.ChaincodeStubInterface, key string, value []byte) (historyEntry, error) {
+	direction, attrArray, err := stub.SplitCompositeKey(key)
+	if err != nil {
+		return historyEntry{}, fmt.Errorf("Split composite key failed! With error: %s", err)
+	}
+	if len(attrArray) != 4 {
+		return historyEntry{}, fmt.Errorf("Malformed history key: %q", key)
+	}
+	record, err := decodeHistory(value)
+	if err != nil {
+		return historyEntry{}, fmt.Errorf("Decode history record failed! With error: %s", err)
+	}
+
+	return historyEntry{
+		Direction:     direction,
+		Account:       attrArray[0],
+		Time:          attrArray[1],
+		TxID:          attrArray[2],
+		historyRecord: record,
+	}, nil
+}
+
+// String formats an entry as it is shown in the query results,
+// following <AccountAccociation | ID | Time | Amount>.
+func (e historyEntry) String() string {
+	arrow := "->"
+	if e.Direction == "in" {
+		arrow = "<-"
+	}
+	str := fmt.Sprintf("%s %s %s\t%s\t%s\t%s %s", e.Account, arrow, e.Counterpart, e.TxID, e.Time, e.Amount, e.Currency)
+	if e.Rate != "" {
+		str += fmt.Sprintf(" (%s)", e.Rate)
+	}
+	if e.Fee != nil {
+		str += fmt.Sprintf(" (fee %s)", e.Fee)
+	}
+	if e.Type != "" {
+		str += fmt.Sprintf(" [%s]", e.Type)
+	}
+	if e.ReversedBy != "" {
+		str += fmt.Sprintf(" [reversed by %s]", e.ReversedBy)
+	}
+	if e.ReversalOf != "" {
+		str += fmt.Sprintf(" [reversal of %s]", e.ReversalOf)
+	}
+	return str
+}
+
+// query one page of the transferring history.
+// args[0] represents the objectType, that is, "in" or "out"
+// args[1] represents the account name
+// args[2] represents the page size
+// args[3] optionally represents the bookmark returned with the previous page
+// Paginated queries are only allowed in read-only transactions.
+func queryPage(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	if args[0] != "in" && args[0] != "out" {
+		return "", fmt.Errorf("You have typed a wrong objectType!")
+	}
+	pageSize, err := strconv.Atoi(args[2])
+	if err != nil || pageSize < 1 || pageSize > maxPageSize {
+		return "", fmt.Errorf("Page size must be a number between 1 and %d!", maxPageSize)
+	}
+	bookmark := ""
+	if len(args) > 3 {
+		bookmark = args[3]
+	}
+
+	it, metadata, err := stub.GetStateByPartialCompositeKeyWithPagination(
+		args[0], []string{args[1]}, int32(pageSize), bookmark)
+	if err != nil {
+		return "", fmt.Errorf("Cannot get by partial composite key with pagination! With error: %s", err)
+	}
+	defer it.Close()
+
+	page := historyPage{Entries: []historyEntry{}}
+	for it.HasNext() {
+		item, err := it.Next()
+		if err != nil {
+			return "", fmt.Errorf("Get next of iterator failed! With error: %s", err)
+		}
+		entry, err := decodeHistoryEntry(stub, item.GetKey(), item.GetValue())
+		if err != nil {
+			return "", err
+		}
+		page.Entries = append(page.Entries, entry)
+	}
+	page.Count = metadata.GetFetchedRecordsCount()
+	page.Bookmark = metadata.GetBookmark()
+	// a short page is the last one
+	if len(page.Entries) < pageSize {
+		page.Bookmark = ""
+	}
+
+	result, err := json.Marshal(page)
+	if err != nil {
+		return "", fmt.Errorf("Encode history page failed! With error: %s", err)
+	}
+	return string(result), nil
+}
+
+// historyFilter holds the bounds of the history function.
+// from is inclusive and to is exclusive, both in RFC3339 UTC so that they
+// compare with the time in history keys as plain strings.
+// min and max are inclusive, a nil bound is not checked.
+type historyFilter struct {
+	from, to string
+	min, max *Money
+}
+
+// parseHistoryFilter parses the optional from, to, min and max arguments.
+// A time bound is either a date such as "2019-07-01", or an RFC3339 time.
+// A date used as the upper bound includes the whole day.
+func parseHistoryFilter(args []string) (historyFilter, error) {
+	var filter historyFilter
+	bounds := make([]string, 4)
+	for i := range bounds {
+		bounds[i] = anyBound
+		if i < len(args) {
+			bounds[i] = args[i]
+		}
+	}
+
+	for i, bound := range bounds[:2] {
+		if bound == anyBound {
+			continue
+		}
+		tm, err := time.Parse(time.RFC3339, bound)
+		if err != nil {
+			if tm, err = time.Parse("2006-01-02", bound); err != nil {
+				return filter, fmt.Errorf("Invalid time bound: %s, expecting 2006-01-02 or RFC3339", bound)
+			}
+			if i == 1 {
+				tm = tm.AddDate(0, 0, 1)
+			}
+		}
+		if i == 0 {
+			filter.from = tm.UTC().Format(time.RFC3339)
+		} else {
+			filter.to = tm.UTC().Format(time.RFC3339)
+		}
+	}
+
+	for i, bound := range bounds[2:] {
+		if bound == anyBound {
+			continue
+		}
+		amount, err := parseDecimal(bound)
+		if err != nil {
+			return filter, fmt.Errorf("Invalid amount bound! With error: %s", err)
+		}
+		if i == 0 {
+			filter.min = &amount
+		} else {
+			filter.max = &amount
+		}
+	}
+
+	return filter, nil
+}
+
+// match reports whether an entry is within the amount bounds.
+func (f historyFilter) match(entry historyEntry) bool {
+	if f.min != nil && entry.Amount.Rat().Cmp(f.min.Rat()) < 0 {
+		return false
+	}
+	if f.max != nil && entry.Amount.Rat().Cmp(f.max.Rat()) > 0 {
+		return false
+	}
+	return true
+}
+
+// query the transferring history with filters.
+// args[0] represents the direction, that is, "in", "out" or "all"
+// args[1] represents the account name
+// args[2] optionally represents the earliest time, inclusive
+// args[3] optionally represents the latest time, exclusive
+// args[4] optionally represents the minimal amount, inclusive
+// args[5] optionally represents the maximal amount, inclusive
+// An omitted filter, or "*", matches everything.
+func history(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	directions := []string{args[0]}
+	switch args[0] {
+	case "in", "out":
+	case "all":
+		directions = []string{"out", "in"}
+	default:
+		return "", fmt.Errorf("You have typed a wrong direction! Expecting \"in\", \"out\" or \"all\".")
+	}
+	filter, err := parseHistoryFilter(args[2:])
+	if err != nil {
+		return "", err
+	}
+
+	page := historyPage{Entries: []historyEntry{}}
+	for _, direction := range directions {
+		entries, err := scanHistory(stub, direction, args[1], filter)
+		if err != nil {
+			return "", err
+		}
+		page.Entries = append(page.Entries, entries...)
+	}
+	page.Count = int32(len(page.Entries))
+
+	result, err := json.Marshal(page)
+	if err != nil {
+		return "", fmt.Errorf("Encode history failed! With error: %s", err)
+	}
+	return string(result), nil
+}
+
+// addHistory writes a history entry of the current transaction, from the side of account.
+// The entries of one transaction between the same two accounts add up to one,
+// eg. the fees a debit account pays in a batch transfer.
+func addHistory(stub shim.ChaincodeStubInterface, direction, account, counterpart string, record historyRecord) error {
+	tm, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	key, err := stub.CreateCompositeKey(direction, []string{account, tm.Format(time.RFC3339), stub.GetTxID(), counterpart})
+	if err != nil {
+		return fmt.Errorf("Create historyKey failed! With error: %s", err)
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return fmt.Errorf("Failed to get history record with error: %s", err)
+	}
+	if value != nil {
+		previous, err := decodeHistory(value)
+		if err != nil {
+			return fmt.Errorf("Decode history record failed! With error: %s", err)
+		}
+		if record.Amount, err = previous.Amount.Add(record.Amount); err != nil {
+			return err
+		}
+	}
+
+	record.Counterpart = counterpart
+	return putHistory(stub, key, record)
+}
+
+// scanHistory collects the history entries of one direction that match the filter.
+// The keys are sorted by time, so the scan starts at the lower bound, as the bookmark
+// of the first page, and stops at the first entry past the upper bound.
+// Paginated queries are only allowed in read-only transactions, as history is.
+func scanHistory(stub shim.ChaincodeStubInterface, direction, account string, filter historyFilter) ([]historyEntry, error) {
+	bookmark := ""
+	if filter.from != "" {
+		var err error
+		if bookmark, err = stub.CreateCompositeKey(direction, []string{account, filter.from}); err != nil {
+			return nil, fmt.Errorf("Create history key failed! With error: %s", err)
+		}
+	}
+
+	var entries []historyEntry
+	for {
+		var err error
+		if entries, bookmark, err = scanHistoryPage(stub, direction, account, filter, bookmark, entries); err != nil {
+			return nil, err
+		}
+		if bookmark == "" {
+			return entries, nil
+		}
+	}
+}
+
+// scanHistoryPage appends the matching entries of one page of at most maxPageSize keys,
+// starting at the bookmark. It returns the bookmark of the next page, empty when the scan is over.
+func scanHistoryPage(stub shim.ChaincodeStubInterface, direction, account string, filter historyFilter,
+	bookmark string, entries []historyEntry) ([]historyEntry, string, error) {
+	it, metadata, err := stub.GetStateByPartialCompositeKeyWithPagination(
+		direction, []string{account}, maxPageSize, bookmark)
+	if err != nil {
+		return nil, "", fmt.Errorf("Cannot get by partial composite key with pagination! With error: %s", err)
+	}
+	defer it.Close()
+
+	fetched := 0
+	for it.HasNext() {
+		item, err := it.Next()
+		if err != nil {
+			return nil, "", fmt.Errorf("Get next of iterator failed! With error: %s", err)
+		}
+		fetched++
+		entry, err := decodeHistoryEntry(stub, item.GetKey(), item.GetValue())
+		if err != nil {
+			return nil, "", err
+		}
+
+		if filter.to != "" && entry.Time >= filter.to {
+			return entries, "", nil
+		}
+		if entry.Time < filter.from || !filter.match(entry) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	// a short page is the last one
+	if fetched < maxPageSize {
+		return entries, "", nil
+	}
+	return entries, metadata.GetBookmark(), nil
+}
+
+// migrateHistory re-keys history records written by older chaincode versions,
+// whose keys were [account] ["->" / "<-"] [counterpart] ["\t"] [uuid] ["\t"] [time]
+// with a time that does not sort. The records themselves are kept as they are,
+// only moved under the sortable key layout of createHistoryKey.
+func migrateHistory(stub shim.ChaincodeStubInterface) (int, error) {
+	migrated := 0
+	for _, direction := range []string{"out", "in"} {
+		count, err := migrateHistoryKeys(stub, direction)
+		migrated += count
+		if err != nil {
+			return migrated, err
+		}
+	}
+	return migrated, nil
+}
+
+// migrateHistoryKeys re-keys the legacy history records of one direction.
+func migrateHistoryKeys(stub shim.ChaincodeStubInterface, direction string) (int, error) {
+	it, err := stub.GetStateByPartialCompositeKey(direction, []string{})
+	if err != nil {
+		return 0, fmt.Errorf("Cannot get by partial composite key! With error: %s", err)
+	}
+	defer it.Close()
+
+	migrated := 0
+	for it.HasNext() {
+		item, err := it.Next()
+		if err != nil {
+			return migrated, fmt.Errorf("Get next of iterator failed! With error: %s", err)
+		}
+		_, attrArray, err := stub.SplitCompositeKey(item.GetKey())
+		if err != nil {
+			return migrated, fmt.Errorf("Split composite key failed! With error: %s", err)
+		}
+		if len(attrArray) != 7 {
+			continue // already in the sortable layout
+		}
+
+		tm, err := time.Parse(legacyTimeFormat, attrArray[6])
+		if err != nil {
+			return migrated, fmt.Errorf("Cannot parse the time of history key: %q", item.GetKey())
+		}
+		record, err := decodeHistory(item.GetValue())
+		if err != nil {
+			return migrated, fmt.Errorf("Decode history record failed! With error: %s", err)
+		}
+		record.Counterpart = attrArray[2]
+
+		newKey, err := stub.CreateCompositeKey(direction, []string{
+			attrArray[0], tm.UTC().Format(time.RFC3339), attrArray[4], attrArray[2],
+		})
+		if err != nil {
+			return migrated, fmt.Errorf("Create historyKey failed! With error: %s", err)
+		}
+		if err := putHistory(stub, newKey, record); err != nil {
+			return migrated, err
+		}
+		if err := stub.DelState(item.GetKey()); err != nil {
+			return migrated, fmt.Errorf("Delete legacy history key failed! With error: %s", err)
+		}
+		migrated++
+	}
+
+	return migrated, nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// pagedStub pages the partial composite key queries, which MockStub leaves out,
+// and records the bookmark every page is asked for with.
+type pagedStub struct {
+	*shim.MockStub
+	bookmarks []string
+}
+
+// pageIterator iterates over the keys of one page.
+type pageIterator struct {
+	items []*queryresult.KV
+}
+
+func (it *pageIterator) HasNext() bool { return len(it.items) > 0 }
+
+func (it *pageIterator) Next() (*queryresult.KV, error) {
+	item := it.items[0]
+	it.items = it.items[1:]
+	return item, nil
+}
+
+func (it *pageIterator) Close() error { return nil }
+
+func (s *pagedStub) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string,
+	pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	s.bookmarks = append(s.bookmarks, bookmark)
+	it, err := s.GetStateByPartialCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer it.Close()
+
+	page, metadata := &pageIterator{}, &peer.QueryResponseMetadata{}
+	for it.HasNext() {
+		item, err := it.Next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if item.Key < bookmark {
+			continue
+		}
+		if int32(len(page.items)) == pageSize {
+			metadata.Bookmark = item.Key
+			break
+		}
+		page.items = append(page.items, item)
+	}
+	metadata.FetchedRecordsCount = int32(len(page.items))
+	return page, metadata, nil
+}
+
+func TestHistoryScanStartsAtFrom(t *testing.T) {
+	stub := &pagedStub{MockStub: shim.NewMockStub("gopenbanking", new(SimpleAsset))}
+	stub.MockTransactionStart("tx000")
+	start := time.Date(2019, 7, 1, 0, 0, 0, 0, time.UTC)
+	at := func(i int) string { return start.Add(time.Duration(i) * time.Minute).Format(time.RFC3339) }
+	for i := 0; i < 2500; i++ {
+		key, err := stub.CreateCompositeKey("out", []string{"alice@ANZBank", at(i), fmt.Sprintf("tx%04d", i), "bob@CitiBank"})
+		if err != nil {
+			t.Fatalf("Create history key failed! With error: %s", err)
+		}
+		value, err := json.Marshal(historyRecord{Counterpart: "bob@CitiBank", Amount: Money{Units: 100, Scale: 2}, Currency: "CNY"})
+		if err != nil {
+			t.Fatalf("Encode history record failed! With error: %s", err)
+		}
+		if err := stub.PutState(key, value); err != nil {
+			t.Fatalf("Store history record failed! With error: %s", err)
+		}
+	}
+
+	result, err := history(stub, []string{"out", "alice@ANZBank", at(1200), at(2300)})
+	if err != nil {
+		t.Fatalf("history failed: %s", err)
+	}
+	var page historyPage
+	if err := json.Unmarshal([]byte(result), &page); err != nil {
+		t.Fatalf("Decode history failed! With error: %s", err)
+	}
+	if len(page.Entries) != 1100 || page.Entries[0].Time != at(1200) || page.Entries[1099].Time != at(2299) {
+		t.Fatalf("history returned %d entries, want the 1100 from %s to %s", len(page.Entries), at(1200), at(2299))
+	}
+
+	// the first page starts at from, the second one carries on where it stopped
+	from, _ := stub.CreateCompositeKey("out", []string{"alice@ANZBank", at(1200)})
+	if len(stub.bookmarks) != 2 || stub.bookmarks[0] != from {
+		t.Errorf("pages asked for with the bookmarks %q, want two from %q", stub.bookmarks, from)
+	}
+}
+
+func TestQueryPageFollowsTheBookmark(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "100")
+	l.open(l.citi, "bob", "0")
+	for i := 1; i <= 5; i++ {
+		l.ok(l.anz, "transfer", "alice", "bob@CitiBank", fmt.Sprint(i))
+	}
+	stub := &pagedStub{MockStub: l.stub}
+
+	var amounts []string
+	bookmark := ""
+	for pages := 1; ; pages++ {
+		args := []string{"out", "alice@ANZBank", "2"}
+		if bookmark != "" {
+			args = append(args, bookmark)
+		}
+		result, err := queryPage(stub, args)
+		if err != nil {
+			t.Fatalf("querypage failed: %s", err)
+		}
+		var page historyPage
+		if err := json.Unmarshal([]byte(result), &page); err != nil {
+			t.Fatalf("Decode history page failed! With error: %s", err)
+		}
+		for _, entry := range page.Entries {
+			amounts = append(amounts, entry.Amount.String())
+		}
+		if bookmark = page.Bookmark; bookmark == "" {
+			if pages != 3 {
+				t.Errorf("history took %d pages, want 3", pages)
+			}
+			break
+		}
+	}
+	if fmt.Sprint(amounts) != "[1.00 2.00 3.00 4.00 5.00]" {
+		t.Errorf("querypage returned %v, want the 5 transfers from the oldest", amounts)
+	}
+
+	l.fail(l.anz, "You have typed a wrong objectType!", "querypage", "sideways", "alice", "2")
+	l.fail(l.anz, "Page size must be a number between 1 and 1000!", "querypage", "out", "alice", "0")
+	l.fail(l.anz, "Page size must be a number between 1 and 1000!", "querypage", "out", "alice", "1001")
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// hold status values
+const (
+	holdActive   = "active"
+	holdCaptured = "captured"
+	holdReleased = "released"
+)
+
+// hold is the JSON document stored under the composite key ["hold", account, id],
+// which reserves an amount of an account for a payment to the beneficiary.
+// The id is the txID of the transaction that placed the hold.
+// An active hold stops counting against the account once it expires.
+// The hold of a remittance is only closed through the remittance.
+type hold struct {
+	ID          string `json:"id"`
+	Account     string `json:"account"`
+	Beneficiary string `json:"beneficiary"`
+	Amount      Money  `json:"amount"`
+	Captured    Money  `json:"captured"`
+	Expiry      string `json:"expiry"`
+	Status      string `json:"status"`
+	CreatedAt   string `json:"createdAt"`
+	ClosedAt    string `json:"closedAt,omitempty"`
+	ClosedBy    string `json:"closedBy,omitempty"` // txID of the capture or release
+	Remittance  bool   `json:"remittance,omitempty"`
+}
+
+// live reports whether a hold still reserves its amount at the given RFC3339 UTC time.
+func (h *hold) live(now string) bool {
+	return h.Status == holdActive && now < h.Expiry
+}
+
+// getHold reads a hold of an account.
+func getHold(stub shim.ChaincodeStubInterface, account, id string) (*hold, error) {
+	key, err := stub.CreateCompositeKey("hold", []string{account, id})
+	if err != nil {
+		return nil, fmt.Errorf("Create hold key failed! With error: %s", err)
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get hold: %s with error: %s", id, err)
+	}
+	if value == nil {
+		return nil, fmt.Errorf("Hold not found: %s on account %s", id, account)
+	}
+
+	h := new(hold)
+	if err := json.Unmarshal(value, h); err != nil {
+		return nil, fmt.Errorf("Corrupted hold: %s with error: %s", id, err)
+	}
+	return h, nil
+}
+
+// putHold writes a hold.
+func putHold(stub shim.ChaincodeStubInterface, h *hold) error {
+	key, err := stub.CreateCompositeKey("hold", []string{h.Account, h.ID})
+	if err != nil {
+		return fmt.Errorf("Create hold key failed! With error: %s", err)
+	}
+	value, err := json.Marshal(h)
+	if err != nil {
+		return fmt.Errorf("Encode hold failed! With error: %s", err)
+	}
+	if err := stub.PutState(key, value); err != nil {
+		return fmt.Errorf("Store hold failed! With error: %s", err)
+	}
+	return nil
+}
+
+// listHolds reads every hold of an account, from the oldest to the newest txID.
+func listHolds(stub shim.ChaincodeStubInterface, account string) ([]hold, error) {
+	it, err := stub.GetStateByPartialCompositeKey("hold", []string{account})
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get by partial composite key! With error: %s", err)
+	}
+	defer it.Close()
+
+	list := []hold{}
+	for it.HasNext() {
+		item, err := it.Next()
+		if err != nil {
+			return nil, fmt.Errorf("Get next of iterator failed! With error: %s", err)
+		}
+		var h hold
+		if err := json.Unmarshal(item.GetValue(), &h); err != nil {
+			return nil, fmt.Errorf("Decode hold failed! With error: %s", err)
+		}
+		list = append(list, h)
+	}
+	return list, nil
+}
+
+// heldAmount adds up the live holds of an account at the transaction time.
+func heldAmount(stub shim.ChaincodeStubInterface, account string, acc *Account) (Money, error) {
+	held := Money{Scale: acc.Balance.Scale}
+	tm, err := txTime(stub)
+	if err != nil {
+		return held, err
+	}
+	list, err := listHolds(stub, account)
+	if err != nil {
+		return held, err
+	}
+
+	now := tm.Format(time.RFC3339)
+	for _, h := range list {
+		if !h.live(now) {
+			continue
+		}
+		if held, err = held.Add(h.Amount); err != nil {
+			return held, err
+		}
+	}
+	return held, nil
+}
+
+// availableFunds returns how much can be taken out of an account,
+// that is, its balance and overdraft less its live holds.
+func availableFunds(stub shim.ChaincodeStubInterface, account string, acc *Account) (Money, error) {
+	available, err := acc.available()
+	if err != nil {
+		return available, err
+	}
+	held, err := heldAmount(stub, account, acc)
+	if err != nil {
+		return available, err
+	}
+	return available.Sub(held)
+}
+
+// parseExpiry parses the expiry of a hold, either a duration from now such as "72h",
+// or an RFC3339 time. The expiry must be in the future.
+func parseExpiry(s string, now time.Time) (time.Time, error) {
+	expiry, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return expiry, fmt.Errorf("Invalid expiry: %s, expecting a duration such as 72h or an RFC3339 time", s)
+		}
+		expiry = now.Add(d)
+	}
+	if !expiry.After(now) {
+		return expiry, fmt.Errorf("Expiry must be in the future: %s", s)
+	}
+	return expiry.UTC(), nil
+}
+
+// checkHoldParty allows the beneficiary side of a hold to capture or release it,
+// and the account side to release it once it has expired.
+// bank, id and admin describe the caller as in checkOwner.
+func checkHoldParty(stub shim.ChaincodeStubInterface, fn, account, holdID, bank, id string, admin bool) error {
+	h, err := getHold(stub, account, holdID)
+	if err != nil {
+		return err
+	}
+	if bankOf(h.Beneficiary) == bank && checkOwner(stub, h.Beneficiary, id, admin) == nil {
+		return nil
+	}
+
+	if fn == "release" && bankOf(h.Account) == bank && checkOwner(stub, h.Account, id, admin) == nil {
+		tm, err := txTime(stub)
+		if err != nil {
+			return err
+		}
+		if !h.live(tm.Format(time.RFC3339)) {
+			return nil
+		}
+		return fmt.Errorf("Hold %s can only be released by its beneficiary before it expires at %s", holdID, h.Expiry)
+	}
+	return fmt.Errorf("You are not allowed to %s hold %s", fn, holdID)
+}
+
+// reserve an amount of an account for a payment
+// args[0] represents the account
+// args[1] represents the full account of the beneficiary
+// args[2] represents the amount
+// args[3] represents the expiry, a duration such as "72h" or an RFC3339 time
+func placeHold(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	if args[0] == args[1] {
+		return "", fmt.Errorf("Cannot hold for the same account: %s", args[0])
+	}
+	acc, err := getAccount(stub, args[0])
+	if err != nil {
+		return "", err
+	}
+	if err := checkUsable(args[0], acc); err != nil {
+		return "", err
+	}
+	if _, err := getAccount(stub, args[1]); err != nil {
+		return "", fmt.Errorf("Invalid beneficiary! With error: %s", err)
+	}
+
+	amount, err := parseAmount(args[2], acc.Balance.Scale)
+	if err != nil {
+		return "", fmt.Errorf("Invalid amount! With Error: %s", err)
+	}
+	available, err := availableFunds(stub, args[0], acc)
+	if err != nil {
+		return "", err
+	}
+	if amount.Cmp(available) > 0 {
+		return "", fmt.Errorf("The balance in %s's account is not enough to hold!", args[0])
+	}
+	if err := checkThreshold(stub, args[0], amount, "Hold"); err != nil {
+		return "", err
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+	expiry, err := parseExpiry(args[3], now)
+	if err != nil {
+		return "", err
+	}
+
+	h := &hold{
+		ID:          stub.GetTxID(),
+		Account:     args[0],
+		Beneficiary: args[1],
+		Amount:      amount,
+		Captured:    Money{Scale: amount.Scale},
+		Expiry:      expiry.Format(time.RFC3339),
+		Status:      holdActive,
+		CreatedAt:   now.Format(time.RFC3339),
+	}
+	if err := putHold(stub, h); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Hold is success! Hold: %s; Account: %s; Amount: %s; Expiry: %s",
+		h.ID, args[0], amount, h.Expiry), nil
+}
+
+// turn a hold into a transfer to its beneficiary, the rest of the hold is released
+// args[0] represents the full account of the hold
+// args[1] represents the hold id
+// args[2] optionally represents the amount to capture, the whole hold by default
+func capture(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	h, err := getHold(stub, args[0], args[1])
+	if err != nil {
+		return "", err
+	}
+	if h.Remittance {
+		return "", fmt.Errorf("Hold %s belongs to a remittance, which the credit bank accepts or rejects!", h.ID)
+	}
+	tm, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+	if h.Status != holdActive {
+		return "", fmt.Errorf("Hold %s is already %s!", h.ID, h.Status)
+	}
+	if !h.live(tm.Format(time.RFC3339)) {
+		return "", fmt.Errorf("Hold %s has expired at %s!", h.ID, h.Expiry)
+	}
+
+	amount := h.Amount
+	if len(args) > 2 {
+		if amount, err = parseAmount(args[2], h.Amount.Scale); err != nil {
+			return "", fmt.Errorf("Invalid amount! With Error: %s", err)
+		}
+		if amount.Cmp(h.Amount) > 0 {
+			return "", fmt.Errorf("Cannot capture %s, more than the hold amount %s!", amount, h.Amount)
+		}
+	}
+
+	// the rule may have changed since the hold was placed
+	if err := checkThreshold(stub, h.Account, amount, "Capture"); err != nil {
+		return "", err
+	}
+	// the held amount is still counted by the ledger, so it is released to the transfer
+	moved, err := moveFunds(stub, h.Account, h.Beneficiary, amount.String(), &h.Amount, nil)
+	if err != nil {
+		return "", err
+	}
+
+	h.Status = holdCaptured
+	h.Captured = amount
+	h.ClosedAt = tm.Format(time.RFC3339)
+	h.ClosedBy = stub.GetTxID()
+	if err := putHold(stub, h); err != nil {
+		return "", err
+	}
+	if err := emitEvent(stub, "capture", moved.legs()...); err != nil {
+		return "", err
+	}
+
+	released, err := h.Amount.Sub(amount)
+	if err != nil {
+		return "", err
+	}
+	result := fmt.Sprintf("Capture is success! Hold: %s; Captured: %s; Released: %s", h.ID, amount, released)
+	if details := moved.String(); details != "" {
+		result += ";" + details
+	}
+	return result, nil
+}
+
+// release a hold without moving any money
+// args[0] represents the full account of the hold
+// args[1] represents the hold id
+func release(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	h, err := getHold(stub, args[0], args[1])
+	if err != nil {
+		return "", err
+	}
+	if h.Remittance {
+		return "", fmt.Errorf("Hold %s belongs to a remittance, which the credit bank accepts or rejects!", h.ID)
+	}
+	if h.Status != holdActive {
+		return "", fmt.Errorf("Hold %s is already %s!", h.ID, h.Status)
+	}
+	tm, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+
+	h.Status = holdReleased
+	h.ClosedAt = tm.Format(time.RFC3339)
+	h.ClosedBy = stub.GetTxID()
+	if err := putHold(stub, h); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Release is success! Hold: %s; Amount: %s", h.ID, h.Amount), nil
+}
+
+// list the holds of an account
+// args[0] represents the full account
+func holds(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	list, err := listHolds(stub, args[0])
+	if err != nil {
+		return "", err
+	}
+
+	result, err := json.Marshal(list)
+	if err != nil {
+		return "", fmt.Errorf("Encode holds failed! With error: %s", err)
+	}
+	return string(result), nil
+}
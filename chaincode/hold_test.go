@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHoldCaptureAndRelease(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "100")
+	l.open(l.citi, "bob", "0")
+
+	l.ok(l.anz, "hold", "alice", "bob@CitiBank", "60", "72h")
+	first := l.lastTx()
+	if got := l.ok(l.anz, "get", "alice"); !strings.Contains(got, "Held: 60.00; Available: 40.00; Balance: 100.00") {
+		t.Errorf("get = %s, want 60.00 held out of 100.00", got)
+	}
+	l.fail(l.anz, "is not enough", "transfer", "alice", "bob@CitiBank", "50")
+	l.fail(l.anz, "The balance in alice@ANZBank's account is not enough to hold!", "hold", "alice", "bob@CitiBank", "41", "72h")
+
+	// the beneficiary captures, the account side only releases what has expired
+	l.fail(l.anz, "You are not allowed to capture hold "+first, "capture", "alice@ANZBank", first)
+	l.fail(l.anz, "Hold "+first+" can only be released by its beneficiary before it expires", "release", "alice@ANZBank", first)
+	l.fail(l.citi, "Cannot capture 60.01, more than the hold amount 60.00!", "capture", "alice@ANZBank", first, "60.01")
+	if got := l.ok(l.citi, "capture", "alice@ANZBank", first, "45"); !strings.Contains(got, "Captured: 45.00; Released: 15.00") {
+		t.Errorf("capture = %s, want 45.00 captured and 15.00 released", got)
+	}
+	l.fail(l.citi, "Hold "+first+" is already captured!", "release", "alice@ANZBank", first)
+
+	l.ok(l.anz, "hold", "alice", "bob@CitiBank", "30", "1h")
+	second := l.lastTx()
+	l.ok(l.citi, "release", "alice@ANZBank", second)
+	l.fail(l.citi, "Hold "+second+" is already released!", "capture", "alice@ANZBank", second)
+
+	// an expired hold no longer counts and cannot be captured
+	l.ok(l.anz, "hold", "alice", "bob@CitiBank", "50", "1h")
+	third := l.lastTx()
+	later := time.Now().Add(2 * time.Hour)
+	if _, err := l.call(later, capture, "alice@ANZBank", third); err == nil || !strings.Contains(err.Error(), "has expired") {
+		t.Errorf("capture of an expired hold = %v, want it expired", err)
+	}
+	if got, err := l.call(later, get, "alice@ANZBank"); err != nil || !strings.Contains(got, "Held: 0.00; Available: 55.00") {
+		t.Errorf("get after the expiry = %s, %v, want nothing held", got, err)
+	}
+
+	if got := l.balance("alice@ANZBank"); got != "55.00" {
+		t.Errorf("balance of alice = %s, want 55.00", got)
+	}
+	if got := l.balance("bob@CitiBank"); got != "45.00" {
+		t.Errorf("balance of bob = %s, want 45.00", got)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// statusKeyConflict is the response status of a request that reuses an idempotency key
+// with other parameters, so that clients can tell it apart from other failures (shim.ERROR).
+const statusKeyConflict = 409
+
+// idempotencyRecord is the JSON document stored under the composite key ["idem", bank, id, key],
+// the first successful request a client of a bank made with an idempotency key.
+// Args are the arguments of the request as the client gave them, without the key.
+type idempotencyRecord struct {
+	Key      string   `json:"key"`
+	Function string   `json:"function"`
+	Args     []string `json:"args"`
+	Result   string   `json:"result"`
+	TxID     string   `json:"txID"`
+	Time     string   `json:"time"`
+}
+
+// idempotent runs a request of a client at most once per idempotency key.
+// id is the identity of the client, so that the clients of a bank do not share their keys.
+// The key is the argument after the expected ones, a request without it always runs.
+// A replay of the same function and arguments returns the first result without running again,
+// a replay with anything else is refused. Failed requests leave no record, so they can be retried.
+func idempotent(stub shim.ChaincodeStubInterface, bank, id, fn string, args []string, expected int, run func() (string, error)) (string, error) {
+	if len(args) <= expected {
+		return run()
+	}
+	idemKey, args := args[expected], args[:expected]
+	if idemKey == "" {
+		return "", fmt.Errorf("The idempotency key must not be empty!")
+	}
+
+	key, err := stub.CreateCompositeKey("idem", []string{bank, id, idemKey})
+	if err != nil {
+		return "", fmt.Errorf("Create idempotency key failed! With error: %s", err)
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return "", fmt.Errorf("Failed to get idempotency key: %s with error: %s", idemKey, err)
+	}
+	if value != nil {
+		var first idempotencyRecord
+		if err := json.Unmarshal(value, &first); err != nil {
+			return "", fmt.Errorf("Corrupted idempotency key: %s with error: %s", idemKey, err)
+		}
+		if first.Function != fn || !sameArgs(first.Args, args) {
+			return "", &codedError{statusKeyConflict, fmt.Sprintf(
+				"Idempotency key %s was used by transaction %s for %s %v!", idemKey, first.TxID, first.Function, first.Args)}
+		}
+		return first.Result, nil
+	}
+
+	result, err := run()
+	if err != nil {
+		return "", err
+	}
+	tm, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+	value, err = json.Marshal(idempotencyRecord{
+		Key:      idemKey,
+		Function: fn,
+		Args:     args,
+		Result:   result,
+		TxID:     stub.GetTxID(),
+		Time:     tm.Format(time.RFC3339),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Encode idempotency key failed! With error: %s", err)
+	}
+	if err := stub.PutState(key, value); err != nil {
+		return "", fmt.Errorf("Store idempotency key failed! With error: %s", err)
+	}
+	return result, nil
+}
+
+// sameArgs reports whether two argument lists are equal.
+func sameArgs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestIdempotencyKeys(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "100")
+	l.open(l.citi, "bob", "100")
+
+	first := l.ok(l.anz, "transfer", "alice", "bob@CitiBank", "10", "pay-1")
+	firstTx := l.lastTx()
+	if replay := l.ok(l.anz, "transfer", "alice", "bob@CitiBank", "10", "pay-1"); replay != first {
+		t.Errorf("replay = %q, want the first result %q", replay, first)
+	}
+	if status := l.fail(l.anz, "Idempotency key pay-1 was used by transaction "+firstTx+" for transfer",
+		"transfer", "alice", "bob@CitiBank", "11", "pay-1"); status != statusKeyConflict {
+		t.Errorf("status = %d, want %d", status, statusKeyConflict)
+	}
+	l.fail(l.anz, "Idempotency key pay-1 was used", "reduce", "alice", "10", "pay-1")
+	l.fail(l.anz, "The idempotency key must not be empty!", "transfer", "alice", "bob@CitiBank", "10", "")
+
+	// the keys of each client are its own, also within a bank
+	l.ok(l.citi, "transfer", "bob", "alice@ANZBank", "5", "pay-1")
+	l.ok(l.anzAdmin, "reduce", "alice", "5", "pay-1")
+
+	// a failed request leaves no record, it can be retried
+	l.fail(l.anz, "is not enough", "transfer", "alice", "bob@CitiBank", "150", "pay-2")
+	l.ok(l.anzAdmin, "add", "alice", "100", "deposit-1")
+	l.ok(l.anzAdmin, "add", "alice", "100", "deposit-1")
+	l.ok(l.anz, "transfer", "alice", "bob@CitiBank", "150", "pay-2")
+
+	if got := l.balance("alice@ANZBank"); got != "40.00" {
+		t.Errorf("balance of alice = %s, want 40.00", got)
+	}
+	if got := l.balance("bob@CitiBank"); got != "255.00" {
+		t.Errorf("balance of bob = %s, want 255.00", got)
+	}
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const (
+	// day count conventions, which turn an annual rate into the rate of one day
+	dayCountAct365 = "ACT/365"
+	dayCountAct360 = "ACT/360"
+	dayCountActAct = "ACT/ACT"
+	dayCount30360  = "30/360"
+
+	// historyInterest is the type of the history entry posting interest to an account
+	historyInterest = "interest"
+)
+
+// product is the JSON document stored under the composite key ["product", bank, name],
+// an interest bearing account product of a bank. Rate is the annual rate as a decimal,
+// eg. "0.035" for 3.5%, and DayCount is one of the day count conventions.
+type product struct {
+	Name      string `json:"name"`
+	Bank      string `json:"bank"`
+	Rate      string `json:"rate"`
+	DayCount  string `json:"dayCount"`
+	UpdatedAt string `json:"updatedAt"`
+}
+
+// parseInterestRate strictly parses a non-negative annual rate such as "0.035".
+func parseInterestRate(s string) (*big.Rat, error) {
+	rate, err := parseDecimal(s)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid rate: %s with error: %s", s, err)
+	}
+	if rate.Sign() < 0 {
+		return nil, fmt.Errorf("Negative rate is not allowed: %s", s)
+	}
+	return rate.Rat(), nil
+}
+
+// dayFraction returns the part of a year that the given day counts for.
+func dayFraction(dayCount string, day time.Time) (*big.Rat, error) {
+	switch dayCount {
+	case dayCountAct365:
+		return big.NewRat(1, 365), nil
+	case dayCountAct360:
+		return big.NewRat(1, 360), nil
+	case dayCountActAct:
+		start := time.Date(day.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+		days := start.AddDate(1, 0, 0).Sub(start) / (24 * time.Hour)
+		return big.NewRat(1, int64(days)), nil
+	case dayCount30360:
+		return big.NewRat(int64(days30360(day, day.AddDate(0, 0, 1))), 360), nil
+	}
+	return nil, fmt.Errorf("Unsupported day count: %s, expecting %s, %s, %s or %s",
+		dayCount, dayCountAct365, dayCountAct360, dayCountActAct, dayCount30360)
+}
+
+// days30360 counts the days from a to b as if every month had 30 days (the US 30/360 rule
+// without the February adjustment), so that the days of a month always add up to 30.
+func days30360(a, b time.Time) int {
+	d1, d2 := a.Day(), b.Day()
+	if d1 == 31 {
+		d1 = 30
+	}
+	if d2 == 31 && d1 == 30 {
+		d2 = 30
+	}
+	return 360*(b.Year()-a.Year()) + 30*(int(b.Month())-int(a.Month())) + d2 - d1
+}
+
+// txDate returns the date of the current transaction, at midnight UTC.
+func txDate(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	tm, err := txTime(stub)
+	if err != nil {
+		return tm, err
+	}
+	return time.Date(tm.Year(), tm.Month(), tm.Day(), 0, 0, 0, 0, time.UTC), nil
+}
+
+// getProduct reads a product of a bank.
+func getProduct(stub shim.ChaincodeStubInterface, bank, name string) (*product, error) {
+	key, err := stub.CreateCompositeKey("product", []string{bank, name})
+	if err != nil {
+		return nil, fmt.Errorf("Create product key failed! With error: %s", err)
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get product: %s with error: %s", name, err)
+	}
+	if value == nil {
+		return nil, fmt.Errorf("Product not found: %s of %s", name, bank)
+	}
+
+	p := new(product)
+	if err := json.Unmarshal(value, p); err != nil {
+		return nil, fmt.Errorf("Corrupted product: %s with error: %s", name, err)
+	}
+	return p, nil
+}
+
+// accruedOf returns the exact interest accrued by an account and not posted yet.
+func accruedOf(account string, acc *Account) (*big.Rat, error) {
+	if acc.Accrued == "" {
+		return new(big.Rat), nil
+	}
+	accrued, ok := new(big.Rat).SetString(acc.Accrued)
+	if !ok {
+		return nil, fmt.Errorf("Corrupted accrued interest of %s: %s", account, acc.Accrued)
+	}
+	return accrued, nil
+}
+
+// postInterest pays the accrued interest, rounded to the scale of the account, out of the
+// reserve of the bank as an "interest" history entry. The rounding difference stays accrued,
+// so nothing is lost over the months. The months posted by one transaction add up to one entry.
+// The caller writes the account back, on a txStub as the reserve may be written several times.
+func postInterest(stub shim.ChaincodeStubInterface, account string, acc *Account, accrued *big.Rat) (Money, error) {
+	amount, err := roundRat(accrued, acc.Balance.Scale)
+	if err != nil {
+		return amount, fmt.Errorf("Post interest of %s failed! With error: %s", account, err)
+	}
+	if amount.Sign() <= 0 {
+		return Money{Scale: acc.Balance.Scale}, nil
+	}
+
+	if _, _, err := settleReserve(stub, account, acc, amount, historyInterest); err != nil {
+		return amount, fmt.Errorf("Post interest of %s failed! With error: %s", account, err)
+	}
+	accrued.Sub(accrued, amount.Rat())
+	return amount, nil
+}
+
+// accrueInterest accrues the interest of every day from AccruedTo up to the day before
+// today on the current balance, and posts the interest of every month it completes.
+// Only a positive balance earns interest. It returns the posted amount,
+// the caller writes the account back.
+func accrueInterest(stub shim.ChaincodeStubInterface, account string, acc *Account, p *product, today time.Time) (Money, error) {
+	posted := Money{Scale: acc.Balance.Scale}
+	rate, err := parseInterestRate(p.Rate)
+	if err != nil {
+		return posted, err
+	}
+	accrued, err := accruedOf(account, acc)
+	if err != nil {
+		return posted, err
+	}
+	day, err := time.Parse("2006-01-02", acc.AccruedTo)
+	if err != nil {
+		return posted, fmt.Errorf("Corrupted accrual date of %s: %s", account, acc.AccruedTo)
+	}
+
+	for ; day.Before(today); day = day.AddDate(0, 0, 1) {
+		if acc.Balance.Sign() > 0 {
+			fraction, err := dayFraction(p.DayCount, day)
+			if err != nil {
+				return posted, err
+			}
+			daily := new(big.Rat).Mul(acc.Balance.Rat(), rate)
+			accrued.Add(accrued, daily.Mul(daily, fraction))
+		}
+
+		// the last day of a month is accrued, post the month
+		if day.AddDate(0, 0, 1).Day() == 1 {
+			amount, err := postInterest(stub, account, acc, accrued)
+			if err != nil {
+				return posted, err
+			}
+			if posted, err = posted.Add(amount); err != nil {
+				return posted, err
+			}
+		}
+	}
+
+	acc.Accrued = accrued.String()
+	acc.AccruedTo = today.Format("2006-01-02")
+	return posted, nil
+}
+
+// a bank admin defines an interest bearing product
+// args[0] represents the bank
+// args[1] represents the product name
+// args[2] represents the annual rate as a decimal, eg. "0.035" for 3.5%
+// args[3] represents the day count convention, that is, "ACT/365", "ACT/360", "ACT/ACT" or "30/360"
+// A new rate applies to every day that is not accrued yet, so accrue before changing it.
+func setProduct(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	if _, err := parseInterestRate(args[2]); err != nil {
+		return "", err
+	}
+	if _, err := dayFraction(args[3], time.Time{}); err != nil {
+		return "", err
+	}
+	tm, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := stub.CreateCompositeKey("product", []string{args[0], args[1]})
+	if err != nil {
+		return "", fmt.Errorf("Create product key failed! With error: %s", err)
+	}
+	value, err := json.Marshal(product{
+		Name:      args[1],
+		Bank:      args[0],
+		Rate:      args[2],
+		DayCount:  args[3],
+		UpdatedAt: tm.Format(time.RFC3339),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Encode product failed! With error: %s", err)
+	}
+	if err := stub.PutState(key, value); err != nil {
+		return "", fmt.Errorf("Store product failed! With error: %s", err)
+	}
+	return fmt.Sprintf("Set product is success! Product: %s; Rate: %s; Day count: %s", args[1], args[2], args[3]), nil
+}
+
+// list the products of a bank
+// args[0] represents the bank
+func products(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	it, err := stub.GetStateByPartialCompositeKey("product", []string{args[0]})
+	if err != nil {
+		return "", fmt.Errorf("Cannot get by partial composite key! With error: %s", err)
+	}
+	defer it.Close()
+
+	list := []product{}
+	for it.HasNext() {
+		item, err := it.Next()
+		if err != nil {
+			return "", fmt.Errorf("Get next of iterator failed! With error: %s", err)
+		}
+		var p product
+		if err := json.Unmarshal(item.GetValue(), &p); err != nil {
+			return "", fmt.Errorf("Decode product failed! With error: %s", err)
+		}
+		list = append(list, p)
+	}
+
+	result, err := json.Marshal(list)
+	if err != nil {
+		return "", fmt.Errorf("Encode products failed! With error: %s", err)
+	}
+	return string(result), nil
+}
+
+// a bank admin puts an account on a product, it accrues interest from today on
+// args[0] represents the full account
+// args[1] represents the product name, "*" takes the account off its product
+// The interest of the old product is accrued and posted first.
+func assignProduct(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	// the reserve may pay several months of interest, each one reads the previous one
+	buffered := newTxStub(stub)
+	acc, err := getAccount(buffered, args[0])
+	if err != nil {
+		return "", err
+	}
+	today, err := txDate(stub)
+	if err != nil {
+		return "", err
+	}
+
+	posted := Money{Scale: acc.Balance.Scale}
+	if acc.Product != "" {
+		old, err := getProduct(buffered, bankOf(args[0]), acc.Product)
+		if err != nil {
+			return "", err
+		}
+		if posted, err = accrueInterest(buffered, args[0], acc, old, today); err != nil {
+			return "", err
+		}
+		// settle the running month with the old product
+		accrued, err := accruedOf(args[0], acc)
+		if err != nil {
+			return "", err
+		}
+		amount, err := postInterest(buffered, args[0], acc, accrued)
+		if err != nil {
+			return "", err
+		}
+		if posted, err = posted.Add(amount); err != nil {
+			return "", err
+		}
+		acc.Accrued = accrued.String()
+	}
+
+	if args[1] == anyBound {
+		// the rounding difference left is less than a minor unit
+		acc.Product, acc.Accrued, acc.AccruedTo = "", "", ""
+	} else {
+		if _, err := getProduct(buffered, bankOf(args[0]), args[1]); err != nil {
+			return "", err
+		}
+		acc.Product = args[1]
+		acc.AccruedTo = today.Format("2006-01-02")
+		if acc.Accrued == "" {
+			acc.Accrued = "0"
+		}
+	}
+
+	if err := putAccount(buffered, args[0], acc); err != nil {
+		return "", err
+	}
+	if err := buffered.flush(); err != nil {
+		return "", fmt.Errorf("Store interest failed! With error: %s", err)
+	}
+	if !posted.IsZero() {
+		if err := emitEvent(stub, "assignproduct", newLeg(args[0], acc, posted)); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("Assign product is success! Account: %s; Product: %s; Posted interest: %s",
+		args[0], args[1], posted), nil
+}
+
+// accrue the interest of every account of a bank on a product up to today,
+// posting the interest of the months that are over.
+// args[0] represents the bank
+// It is meant to be invoked once a day; the days it catches up accrue on the current balance.
+// An account whose interest cannot be posted, eg. as the reserve runs short, is skipped and
+// reported, it catches up on the next run.
+func accrue(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	today, err := txDate(stub)
+	if err != nil {
+		return "", err
+	}
+
+	// an empty range visits every simple key, that is, every account
+	it, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return "", fmt.Errorf("Cannot get accounts by range! With error: %s", err)
+	}
+	defer it.Close()
+
+	// the accounts share the reserve that pays their interest
+	buffered := newTxStub(stub)
+	accrued := 0
+	var legs []eventLeg
+	var skipped []string
+	catalog := make(map[string]*product)
+	for it.HasNext() {
+		item, err := it.Next()
+		if err != nil {
+			return "", fmt.Errorf("Get next of iterator failed! With error: %s", err)
+		}
+		account := item.GetKey()
+		if bankOf(account) != args[0] {
+			continue
+		}
+		acc := new(Account)
+		if err := json.Unmarshal(item.GetValue(), acc); err != nil {
+			return "", fmt.Errorf("Corrupted asset: %s with error: %s", account, err)
+		}
+		acc.upgrade()
+		if acc.Product == "" {
+			continue
+		}
+
+		p, ok := catalog[acc.Product]
+		if !ok {
+			if p, err = getProduct(buffered, args[0], acc.Product); err != nil {
+				return "", err
+			}
+			catalog[acc.Product] = p
+		}
+		// a skipped account leaves nothing behind, not even the interest it accrued
+		savepoint := newTxStub(buffered)
+		posted, err := accrueInterest(savepoint, account, acc, p, today)
+		if err == nil {
+			err = putAccount(savepoint, account, acc)
+		}
+		if err == nil {
+			err = savepoint.flush()
+		}
+		if err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s (%s)", account, err))
+			continue
+		}
+		accrued++
+		if !posted.IsZero() {
+			legs = append(legs, newLeg(account, acc, posted))
+		}
+	}
+
+	if err := buffered.flush(); err != nil {
+		return "", fmt.Errorf("Store interest failed! With error: %s", err)
+	}
+	if err := emitEvent(stub, "accrue", legs...); err != nil {
+		return "", err
+	}
+	result := fmt.Sprintf("Accrue is success! Accounts: %d; Posted: %d; Date: %s",
+		accrued, len(legs), today.Format("2006-01-02"))
+	if len(skipped) > 0 {
+		result += fmt.Sprintf("; Skipped: %s", strings.Join(skipped, ", "))
+	}
+	return result, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDayFraction(t *testing.T) {
+	day := func(y int, m time.Month, d int) time.Time { return time.Date(y, m, d, 0, 0, 0, 0, time.UTC) }
+	for _, c := range []struct {
+		dayCount string
+		day      time.Time
+		want     *big.Rat
+	}{
+		{dayCountAct365, day(2024, 2, 29), big.NewRat(1, 365)},
+		{dayCountAct360, day(2023, 7, 31), big.NewRat(1, 360)},
+		{dayCountActAct, day(2023, 3, 1), big.NewRat(1, 365)},
+		{dayCountActAct, day(2024, 3, 1), big.NewRat(1, 366)},
+		{dayCount30360, day(2023, 7, 15), big.NewRat(1, 360)},
+		// the 31st counts for nothing, the 30th of a 31 day month leads into it
+		{dayCount30360, day(2023, 7, 30), big.NewRat(0, 360)},
+		{dayCount30360, day(2023, 7, 31), big.NewRat(1, 360)},
+		// the last day of February makes up the month to 30 days
+		{dayCount30360, day(2023, 2, 28), big.NewRat(3, 360)},
+		{dayCount30360, day(2024, 2, 28), big.NewRat(1, 360)},
+		{dayCount30360, day(2024, 2, 29), big.NewRat(2, 360)},
+	} {
+		got, err := dayFraction(c.dayCount, c.day)
+		if err != nil || got.Cmp(c.want) != 0 {
+			t.Errorf("dayFraction(%s, %s) = %v, %v, want %v", c.dayCount, c.day.Format("2006-01-02"), got, err, c.want)
+		}
+	}
+	if _, err := dayFraction("ACT/364", day(2023, 1, 1)); err == nil || !strings.Contains(err.Error(), "Unsupported day count: ACT/364") {
+		t.Errorf("dayFraction(ACT/364) = %v, want unsupported", err)
+	}
+
+	// under 30/360 the days of every month add up to 30
+	for _, month := range []time.Time{day(2023, 1, 1), day(2023, 2, 1), day(2024, 2, 1), day(2023, 4, 1)} {
+		if days := days30360(month, month.AddDate(0, 1, 0)); days != 30 {
+			t.Errorf("days30360 of %s = %d, want 30", month.Format("2006-01"), days)
+		}
+	}
+}
+
+func TestAccruePostsMonthlyInterest(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "1000")
+	l.open(l.anz, "bob", "1000")
+	// 0.365 over ACT/365 earns 1 a day on 1000, 0.1 over ACT/360 earns 0.2777... a day
+	l.ok(l.anzAdmin, "setproduct", "saver", "0.365", "ACT/365")
+	l.ok(l.anzAdmin, "setproduct", "odd", "0.1", "ACT/360")
+	start := time.Date(2023, 1, 1, 9, 0, 0, 0, time.UTC)
+	for account, product := range map[string]string{"alice@ANZBank": "saver", "bob@ANZBank": "odd"} {
+		if _, err := l.call(start, assignProduct, account, product); err != nil {
+			t.Fatalf("assignproduct %s failed: %s", account, err)
+		}
+	}
+
+	// nothing is posted before a month is over
+	result, err := l.call(start.AddDate(0, 0, 30), accrue, "ANZBank")
+	if err != nil || !strings.Contains(result, "Accounts: 2; Posted: 0; Date: 2023-01-31") {
+		t.Fatalf("accrue = %s, %v, want both accrued and none posted", result, err)
+	}
+	if acc := l.account("alice@ANZBank"); acc.Balance.String() != "1000.00" || acc.Accrued != "30/1" || acc.AccruedTo != "2023-01-31" {
+		t.Errorf("alice = %+v, want 30 accrued to 2023-01-31", acc)
+	}
+
+	// January is posted as interest, bob keeps the part of a cent that 3100/360 leaves over
+	if _, err := l.call(start.AddDate(0, 1, 0), accrue, "ANZBank"); err != nil {
+		t.Fatalf("accrue failed: %s", err)
+	}
+	if acc := l.account("bob@ANZBank"); acc.Balance.String() != "1008.61" || acc.Accrued != "1/900" {
+		t.Errorf("bob = %+v, want 8.61 posted and 1/900 accrued", acc)
+	}
+	if got := l.ok(l.anz, "query", "in", "alice"); !strings.Contains(got, "31.00 CNY [interest]") {
+		t.Errorf("query in of alice = %s, want the interest of January", got)
+	}
+
+	// a late run catches up February and March, each month on the balance it ends with,
+	// and posts them as one entry: 28 * 1.031 = 28.868 and 31 * 1.05987 + -0.002 = 32.85397
+	result, err = l.call(start.AddDate(0, 3, 0), accrue, "ANZBank")
+	if err != nil || !strings.Contains(result, "Accounts: 2; Posted: 2") {
+		t.Fatalf("accrue = %s, %v, want both posted", result, err)
+	}
+	if got := l.balance("alice@ANZBank"); got != "1092.72" {
+		t.Errorf("balance of alice = %s, want 1092.72", got)
+	}
+	if got := l.ok(l.anz, "query", "in", "alice"); !strings.Contains(got, "61.72 CNY [interest]") {
+		t.Errorf("query in of alice = %s, want February and March posted together", got)
+	}
+}
+
+func TestAssignProductSettlesTheOldOne(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "1000")
+	l.ok(l.anzAdmin, "setproduct", "saver", "0.365", "ACT/365")
+	l.ok(l.anzAdmin, "setproduct", "basic", "0.01", "ACT/365")
+	start := time.Date(2023, 1, 1, 9, 0, 0, 0, time.UTC)
+	if _, err := l.call(start, assignProduct, "alice@ANZBank", "saver"); err != nil {
+		t.Fatalf("assignproduct failed: %s", err)
+	}
+
+	// the ten days on saver are posted at its rate before basic takes over mid-month
+	result, err := l.call(start.AddDate(0, 0, 10), assignProduct, "alice@ANZBank", "basic")
+	if err != nil || !strings.Contains(result, "Posted interest: 10.00") {
+		t.Fatalf("assignproduct = %s, %v, want 10.00 posted", result, err)
+	}
+	acc := l.account("alice@ANZBank")
+	if acc.Balance.String() != "1010.00" || acc.Product != "basic" || acc.Accrued != "0/1" || acc.AccruedTo != "2023-01-11" {
+		t.Errorf("alice = %+v, want 10.00 posted and basic from 2023-01-11", acc)
+	}
+
+	// taking the account off its product settles it as well
+	result, err = l.call(start.AddDate(0, 0, 20), assignProduct, "alice@ANZBank", "*")
+	if err != nil || !strings.Contains(result, "Posted interest: 0.28") {
+		t.Fatalf("assignproduct = %s, %v, want the ten days on basic posted", result, err)
+	}
+	if acc := l.account("alice@ANZBank"); acc.Product != "" || acc.Accrued != "" || acc.AccruedTo != "" {
+		t.Errorf("alice = %+v, want it off any product", acc)
+	}
+}
+
+func TestAccrueSkipsWhatTheReserveCannotPay(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "1000")
+	// rich takes the whole USD reserve, which has nothing left for its interest
+	l.open(l.anz, "rich", "1000000", "USD")
+	l.ok(l.anzAdmin, "setproduct", "saver", "0.365", "ACT/365")
+	l.ok(l.anzAdmin, "assignproduct", "alice", "saver")
+	l.ok(l.anzAdmin, "assignproduct", "rich", "saver")
+	before := l.account("rich@ANZBank")
+
+	result, err := l.call(time.Now().AddDate(0, 2, 0), accrue, "ANZBank")
+	if err != nil {
+		t.Fatalf("accrue failed: %s", err)
+	}
+	if !strings.Contains(result, "Accounts: 1; Posted: 1") ||
+		!strings.Contains(result, "Skipped: rich@ANZBank (Post interest of rich@ANZBank failed! With error: The reserve $reserve.USD@ANZBank is not enough") {
+		t.Errorf("accrue = %s, want alice posted and rich skipped", result)
+	}
+
+	// alice earned about 1 a day, rich is left as it was to catch up on the next run
+	if got := l.account("alice@ANZBank").Balance; got.Rat().Cmp(big.NewRat(1000, 1)) <= 0 {
+		t.Errorf("balance of alice = %s, want the interest posted", got)
+	}
+	after := l.account("rich@ANZBank")
+	if after.Balance != before.Balance || after.AccruedTo != before.AccruedTo || after.Accrued != before.Accrued {
+		t.Errorf("rich = %+v, want it untouched as %+v", after, before)
+	}
+	if got := l.balance(reserveAccount("ANZBank", "USD")); got != "0.00" {
+		t.Errorf("balance of the USD reserve = %s, want 0.00", got)
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// statusLimitExceeded is the response status of a transfer rejected by a transfer limit,
+// so that clients can tell it apart from other failures (shim.ERROR).
+const statusLimitExceeded = 429
+
+// codedError is an error answered with its own response status instead of shim.ERROR.
+type codedError struct {
+	status  int32
+	message string
+}
+
+func (e *codedError) Error() string {
+	return e.message
+}
+
+// transferLimits is the JSON document stored under the composite key ["limit", account]
+// for the limits of an account, or ["limit", bank, currency] for those of a bank.
+// The limits of an account cap its own outgoing transfers. The limits of a bank cap
+// each transfer out of its accounts in the currency, and their daily amount and count
+// cap the outgoing transfers of all those accounts together.
+// Amounts are in Currency; an empty amount or a zero count is unlimited.
+// The daily limits count the transfers made since they were set on that day.
+type transferLimits struct {
+	Currency    string `json:"currency"`
+	MaxPerTx    string `json:"maxPerTx,omitempty"`
+	DailyAmount string `json:"dailyAmount,omitempty"`
+	DailyCount  int    `json:"dailyCount,omitempty"`
+	UpdatedAt   string `json:"updatedAt"`
+}
+
+// dailyUsage is the JSON document stored under the composite key ["usage", account, date, txID],
+// which adds up the outgoing transfers of an account in one transaction of a UTC day,
+// or ["usage", bank, currency, date, txID] for those of all the accounts of a bank in the currency.
+// Each transaction writes a key of its own, so the transfers of a bank do not all rewrite one key;
+// the usage of a day is the sum of its keys. Only a scope with a daily limit keeps its usage.
+type dailyUsage struct {
+	Amount Money `json:"amount"`
+	Count  int   `json:"count"`
+}
+
+// readUsage adds up the daily usage of a scope, that is, an account and a date
+// or a bank, a currency and a date. scale is the scale of the amounts.
+func readUsage(stub shim.ChaincodeStubInterface, scale int, scope ...string) (dailyUsage, error) {
+	usage := dailyUsage{Amount: Money{Scale: scale}}
+	it, err := stub.GetStateByPartialCompositeKey("usage", scope)
+	if err != nil {
+		return usage, fmt.Errorf("Cannot get by partial composite key! With error: %s", err)
+	}
+	defer it.Close()
+
+	for it.HasNext() {
+		item, err := it.Next()
+		if err != nil {
+			return usage, fmt.Errorf("Get next of iterator failed! With error: %s", err)
+		}
+		var delta dailyUsage
+		if err := json.Unmarshal(item.GetValue(), &delta); err != nil {
+			return usage, fmt.Errorf("Corrupted usage: %v with error: %s", scope, err)
+		}
+		if usage.Amount, err = usage.Amount.Add(delta.Amount); err != nil {
+			return usage, err
+		}
+		usage.Count += delta.Count
+	}
+	return usage, nil
+}
+
+// add counts one more transfer of amount into the usage.
+func (usage *dailyUsage) add(amount Money) error {
+	total, err := usage.Amount.Add(amount)
+	if err != nil {
+		return err
+	}
+	usage.Amount = total
+	usage.Count++
+	return nil
+}
+
+// putUsage adds a transfer of amount to the usage of a scope in the current transaction,
+// which may already have made other transfers, eg. the legs of a batch.
+func putUsage(stub shim.ChaincodeStubInterface, amount Money, scope ...string) error {
+	key, err := stub.CreateCompositeKey("usage", append(append([]string{}, scope...), stub.GetTxID()))
+	if err != nil {
+		return fmt.Errorf("Create usage key failed! With error: %s", err)
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return fmt.Errorf("Failed to get usage: %v with error: %s", scope, err)
+	}
+	usage := dailyUsage{Amount: Money{Scale: amount.Scale}}
+	if value != nil {
+		if err := json.Unmarshal(value, &usage); err != nil {
+			return fmt.Errorf("Corrupted usage: %v with error: %s", scope, err)
+		}
+	}
+	if err := usage.add(amount); err != nil {
+		return err
+	}
+
+	if value, err = json.Marshal(usage); err != nil {
+		return fmt.Errorf("Encode usage failed! With error: %s", err)
+	}
+	if err := stub.PutState(key, value); err != nil {
+		return fmt.Errorf("Store usage failed! With error: %s", err)
+	}
+	return nil
+}
+
+// readLimits reads the limits of a scope, that is, an account or a bank and a currency.
+// It returns nil if the scope has none.
+func readLimits(stub shim.ChaincodeStubInterface, scope ...string) (*transferLimits, error) {
+	key, err := stub.CreateCompositeKey("limit", scope)
+	if err != nil {
+		return nil, fmt.Errorf("Create limit key failed! With error: %s", err)
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get limits: %v with error: %s", scope, err)
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	limits := new(transferLimits)
+	if err := json.Unmarshal(value, limits); err != nil {
+		return nil, fmt.Errorf("Corrupted limits: %v with error: %s", scope, err)
+	}
+	return limits, nil
+}
+
+// parseLimit validates an optional amount limit, "*" means unlimited.
+func parseLimit(s string) (string, error) {
+	if s == anyBound {
+		return "", nil
+	}
+	limit, err := parseDecimal(s)
+	if err != nil {
+		return "", err
+	}
+	if limit.Sign() < 0 {
+		return "", fmt.Errorf("Limit must not be negative: %s", s)
+	}
+	return limit.String(), nil
+}
+
+// a bank sets the transfer limits of one of its accounts, or of itself
+// args[0] represents the scope, that is, a full account or a bank
+// args[1] represents the maximum amount of one transfer
+// args[2] represents the maximum amount transferred out in one day
+// args[3] represents the maximum number of transfers in one day
+// args[4] optionally represents the currency of the limits, the limits of an account
+// are in its own currency and those of a bank in CNY by default
+// "*" stands for no limit.
+func setLimits(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	var limits transferLimits
+	currency := ""
+	if len(args) > 4 {
+		currency = args[4]
+	}
+	scope := []string{args[0]}
+	if strings.Contains(args[0], "@") {
+		acc, err := getAccount(stub, args[0])
+		if err != nil {
+			return "", err
+		}
+		if currency != "" && currency != acc.Currency {
+			return "", fmt.Errorf("The limits of %s are in its currency %s, not %s!", args[0], acc.Currency, currency)
+		}
+		currency = acc.Currency
+	} else {
+		if currency == "" {
+			currency = defaultCurrency
+		}
+		if _, err := currencyScale(currency); err != nil {
+			return "", err
+		}
+		scope = append(scope, currency)
+	}
+	limits.Currency = currency
+
+	var err error
+	if limits.MaxPerTx, err = parseLimit(args[1]); err != nil {
+		return "", fmt.Errorf("Invalid per transaction limit! With error: %s", err)
+	}
+	if limits.DailyAmount, err = parseLimit(args[2]); err != nil {
+		return "", fmt.Errorf("Invalid daily amount limit! With error: %s", err)
+	}
+	if args[3] != anyBound {
+		if limits.DailyCount, err = strconv.Atoi(args[3]); err != nil || limits.DailyCount < 0 {
+			return "", fmt.Errorf("Invalid daily count limit: %s", args[3])
+		}
+	}
+
+	tm, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+	limits.UpdatedAt = tm.Format(time.RFC3339)
+
+	key, err := stub.CreateCompositeKey("limit", scope)
+	if err != nil {
+		return "", fmt.Errorf("Create limit key failed! With error: %s", err)
+	}
+	value, err := json.Marshal(limits)
+	if err != nil {
+		return "", fmt.Errorf("Encode limits failed! With error: %s", err)
+	}
+	if err := stub.PutState(key, value); err != nil {
+		return "", fmt.Errorf("Store limits failed! With error: %s", err)
+	}
+
+	return fmt.Sprintf("Set limits is success! Scope: %s; Currency: %s; Per transaction: %s; Daily amount: %s; Daily count: %s",
+		args[0], currency, args[1], args[2], args[3]), nil
+}
+
+// chargeLimits checks an outgoing transfer, of an amount in the currency of the debit account,
+// against the limits of the account and those of its bank, and adds it to the daily usage
+// of both. It returns a codedError with statusLimitExceeded when a limit is hit.
+func chargeLimits(stub shim.ChaincodeStubInterface, account string, amount Money, currency string) error {
+	bank := bankOf(account)
+	accountLimits, err := readLimits(stub, account)
+	if err != nil {
+		return err
+	}
+	bankLimits, err := readLimits(stub, bank, currency)
+	if err != nil {
+		return err
+	}
+
+	tm, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	date := tm.Format("2006-01-02")
+	if err := accountLimits.charge(stub, account, amount, account, date); err != nil {
+		return err
+	}
+	return bankLimits.charge(stub, bank, amount, bank, currency, date)
+}
+
+// daily reports whether the limits cap the transfers of a day, only then is the usage kept.
+func (limits *transferLimits) daily() bool {
+	return limits != nil && (limits.DailyAmount != "" || limits.DailyCount > 0)
+}
+
+// charge checks a transfer of amount against the limits set for name, and adds it
+// to the usage of scope, that is, name and the day, when the limits cap a day.
+func (limits *transferLimits) charge(stub shim.ChaincodeStubInterface, name string, amount Money, scope ...string) error {
+	usage := dailyUsage{Amount: Money{Scale: amount.Scale}}
+	if limits.daily() {
+		var err error
+		if usage, err = readUsage(stub, amount.Scale, scope...); err != nil {
+			return err
+		}
+	}
+	if err := usage.add(amount); err != nil {
+		return err
+	}
+	if err := limits.check(name, amount, usage); err != nil {
+		return err
+	}
+	if !limits.daily() {
+		return nil
+	}
+	return putUsage(stub, amount, scope...)
+}
+
+// check returns a codedError with statusLimitExceeded when a transfer of amount, which brings
+// the daily usage of scope to usage, breaks the limits set for scope. Nil limits pass.
+func (limits *transferLimits) check(scope string, amount Money, usage dailyUsage) error {
+	if limits == nil {
+		return nil
+	}
+	if exceeds(amount, limits.MaxPerTx) {
+		return &codedError{statusLimitExceeded, fmt.Sprintf(
+			"Transfer limit exceeded! %s %s exceeds the per transaction limit %s of %s", amount, limits.Currency, limits.MaxPerTx, scope)}
+	}
+	if exceeds(usage.Amount, limits.DailyAmount) {
+		return &codedError{statusLimitExceeded, fmt.Sprintf(
+			"Transfer limit exceeded! %s %s would be transferred out of %s today, over its daily limit %s",
+			usage.Amount, limits.Currency, scope, limits.DailyAmount)}
+	}
+	if limits.DailyCount > 0 && usage.Count > limits.DailyCount {
+		return &codedError{statusLimitExceeded, fmt.Sprintf(
+			"Transfer limit exceeded! %s has reached its daily limit of %d transfers", scope, limits.DailyCount)}
+	}
+	return nil
+}
+
+// exceeds reports whether an amount is over a limit, an empty limit is never exceeded.
+func exceeds(amount Money, limit string) bool {
+	if limit == "" {
+		return false
+	}
+	max, ok := new(big.Rat).SetString(limit)
+	return !ok || amount.Rat().Cmp(max) > 0
+}
@@ -0,0 +1,66 @@
+package main
+
+import "testing"
+
+func TestAccountAndBankLimits(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "1000")
+	l.open(l.anz, "carol", "0")
+	l.open(l.anz, "dave", "1000", "USD")
+	l.open(l.anz, "erin", "0", "USD")
+
+	// the bank allows 100 CNY per transfer, the account 500: the bank wins
+	l.ok(l.anzAdmin, "setlimits", "*", "100", "*", "0")
+	l.ok(l.anzAdmin, "setlimits", "alice", "500", "*", "0")
+	if status := l.fail(l.anz, "exceeds the per transaction limit", "transfer", "alice", "carol@ANZBank", "200"); status != statusLimitExceeded {
+		t.Errorf("status = %d, want %d", status, statusLimitExceeded)
+	}
+	l.fail(l.anz, "of ANZBank", "transfer", "alice", "carol@ANZBank", "200")
+	l.ok(l.anz, "transfer", "alice", "carol@ANZBank", "100")
+
+	// the account allows 50 CNY per transfer, the bank 100: the account wins
+	l.ok(l.anzAdmin, "setlimits", "alice", "50", "*", "0")
+	l.fail(l.anz, "of alice@ANZBank", "transfer", "alice", "carol@ANZBank", "60")
+	l.ok(l.anz, "transfer", "alice", "carol@ANZBank", "50")
+
+	// the limits of an account are in its own currency
+	l.fail(l.anzAdmin, "The limits of alice@ANZBank are in its currency CNY, not USD!", "setlimits", "alice", "50", "*", "0", "USD")
+	l.fail(l.anzAdmin, "currency", "setlimits", "*", "50", "*", "0", "XYZ")
+
+	// the CNY limits of the bank leave its USD accounts alone, until it sets USD limits
+	l.ok(l.anz, "transfer", "dave", "erin@ANZBank", "200")
+	l.ok(l.anzAdmin, "setlimits", "*", "100", "*", "0", "USD")
+	l.fail(l.anz, "200.00 USD exceeds the per transaction limit", "transfer", "dave", "erin@ANZBank", "200")
+
+	// per transaction limits keep no daily usage, which every transfer of the bank would write
+	it, err := l.stub.GetStateByPartialCompositeKey("usage", []string{})
+	if err != nil {
+		t.Fatalf("Cannot get by partial composite key! With error: %s", err)
+	}
+	if it.HasNext() {
+		t.Errorf("usage kept without a daily limit")
+	}
+	it.Close()
+
+	// the daily amount of the bank adds up the transfers of all its accounts from when it is set,
+	// so splitting them across accounts does not get around it
+	l.ok(l.anzAdmin, "setlimits", "*", "*", "200", "0")
+	l.ok(l.anz, "transfer", "alice", "carol@ANZBank", "50")
+	l.ok(l.anz, "transfer", "carol", "alice@ANZBank", "150")
+	l.fail(l.anz, "200.01 CNY would be transferred out of ANZBank today, over its daily limit 200",
+		"transfer", "alice", "carol@ANZBank", "0.01")
+
+	// so does the daily count of the bank, which covers the transfers counted so far today
+	l.ok(l.anzAdmin, "setlimits", "*", "*", "*", "4")
+	l.ok(l.anz, "transfer", "carol", "alice@ANZBank", "1")
+	l.ok(l.anz, "transfer", "alice", "carol@ANZBank", "1")
+	l.fail(l.anz, "ANZBank has reached its daily limit of 4 transfers", "transfer", "carol", "alice@ANZBank", "1")
+	l.ok(l.anz, "transfer", "dave", "erin@ANZBank", "1")
+
+	if got := l.balance("alice@ANZBank"); got != "950.00" {
+		t.Errorf("balance of alice = %s, want 950.00", got)
+	}
+	if got := l.balance("erin@ANZBank"); got != "201.00" {
+		t.Errorf("balance of erin = %s, want 201.00", got)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+const (
+	// defaultScale is the number of decimal places kept for balances and amounts
+	defaultScale = 2
+	// maxScale is the largest scale whose minor units still fit into an int64
+	maxScale = 18
+)
+
+// Money is a fixed-point decimal amount.
+// Units is the number of minor units (eg. cents) and Scale is the number of
+// decimal places, so Money{Units: 1234, Scale: 2} represents 12.34.
+// All arithmetic is exact; an operation that would overflow returns an error.
+type Money struct {
+	Units int64
+	Scale int
+}
+
+// parseMoney strictly parses a non-negative decimal string such as "12.34"
+// into Money with the given scale.
+// Signs, exponents, spaces, redundant leading zeros and more fraction digits
+// than the scale allows are all rejected.
+func parseMoney(s string, scale int) (Money, error) {
+	if scale < 0 || scale > maxScale {
+		return Money{}, fmt.Errorf("Invalid scale: %d", scale)
+	}
+	if strings.HasPrefix(s, "-") {
+		return Money{}, fmt.Errorf("Negative amount is not allowed: %s", s)
+	}
+
+	intPart, fracPart := s, ""
+	if dot := strings.IndexByte(s, '.'); dot >= 0 {
+		intPart, fracPart = s[:dot], s[dot+1:]
+		if fracPart == "" {
+			return Money{}, fmt.Errorf("Malformed amount: %q", s)
+		}
+	}
+	if intPart == "" || !isDigits(intPart) || !isDigits(fracPart) {
+		return Money{}, fmt.Errorf("Malformed amount: %q", s)
+	}
+	if len(intPart) > 1 && intPart[0] == '0' {
+		return Money{}, fmt.Errorf("Malformed amount: %q", s)
+	}
+	if len(fracPart) > scale {
+		return Money{}, fmt.Errorf("Amount %s has more than %d decimal places", s, scale)
+	}
+
+	// pad the fraction to the full scale, then read all digits as minor units
+	digits := intPart + fracPart + strings.Repeat("0", scale-len(fracPart))
+	var units int64
+	for _, c := range digits {
+		d := int64(c - '0')
+		if units > (math.MaxInt64-d)/10 {
+			return Money{}, fmt.Errorf("Amount is too large: %s", s)
+		}
+		units = units*10 + d
+	}
+
+	return Money{Units: units, Scale: scale}, nil
+}
+
+// parseAmount parses an amount to be moved, which must be strictly positive.
+// scale is the scale of the account the amount is moved in or out of.
+func parseAmount(s string, scale int) (Money, error) {
+	amount, err := parseMoney(s, scale)
+	if err != nil {
+		return Money{}, err
+	}
+	if amount.IsZero() {
+		return Money{}, fmt.Errorf("Amount must be greater than zero: %s", s)
+	}
+	return amount, nil
+}
+
+// isDigits reports whether s only contains ASCII digits.
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Add returns m + o. Both amounts must have the same scale.
+func (m Money) Add(o Money) (Money, error) {
+	if m.Scale != o.Scale {
+		return Money{}, fmt.Errorf("Scale mismatch: %d and %d", m.Scale, o.Scale)
+	}
+	if (o.Units > 0 && m.Units > math.MaxInt64-o.Units) ||
+		(o.Units < 0 && m.Units < math.MinInt64-o.Units) {
+		return Money{}, fmt.Errorf("Amount overflow: %s + %s", m, o)
+	}
+	return Money{Units: m.Units + o.Units, Scale: m.Scale}, nil
+}
+
+// Sub returns m - o. Both amounts must have the same scale.
+func (m Money) Sub(o Money) (Money, error) {
+	if o.Units == math.MinInt64 {
+		return Money{}, fmt.Errorf("Amount overflow: %s - %s", m, o)
+	}
+	return m.Add(o.Neg())
+}
+
+// Neg returns -m.
+func (m Money) Neg() Money {
+	return Money{Units: -m.Units, Scale: m.Scale}
+}
+
+// Cmp compares m and o and returns -1, 0 or +1.
+// Amounts of different scales are compared by their exact values, eg. 1.5 equals 1.50.
+func (m Money) Cmp(o Money) int {
+	if m.Scale != o.Scale {
+		return m.Rat().Cmp(o.Rat())
+	}
+	switch {
+	case m.Units < o.Units:
+		return -1
+	case m.Units > o.Units:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Sign returns -1, 0 or +1 depending on the sign of m.
+func (m Money) Sign() int {
+	return m.Cmp(Money{Scale: m.Scale})
+}
+
+// IsZero reports whether m is zero.
+func (m Money) IsZero() bool {
+	return m.Units == 0
+}
+
+// Rat returns the exact value of m as a rational number.
+func (m Money) Rat() *big.Rat {
+	return new(big.Rat).SetFrac(big.NewInt(m.Units), pow10(m.Scale))
+}
+
+// MulRat returns m * r rounded to the given scale, see roundRat.
+func (m Money) MulRat(r *big.Rat, scale int) (Money, error) {
+	return roundRat(new(big.Rat).Mul(m.Rat(), r), scale)
+}
+
+// roundRat rounds r half away from zero to the given scale.
+// big.Rat arithmetic is exact, so the result is identical on every peer.
+func roundRat(r *big.Rat, scale int) (Money, error) {
+	if scale < 0 || scale > maxScale {
+		return Money{}, fmt.Errorf("Invalid scale: %d", scale)
+	}
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt(pow10(scale)))
+
+	quo, rem := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	// round away from zero when the remainder is at least half of the denominator
+	twice := new(big.Int).Lsh(new(big.Int).Abs(rem), 1)
+	if twice.Cmp(scaled.Denom()) >= 0 {
+		quo.Add(quo, big.NewInt(int64(scaled.Num().Sign())))
+	}
+	if !quo.IsInt64() {
+		return Money{}, fmt.Errorf("Amount overflow: %s", r.FloatString(scale))
+	}
+
+	return Money{Units: quo.Int64(), Scale: scale}, nil
+}
+
+// pow10 returns 10^n as a big.Int.
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+// String formats m with exactly Scale decimal places, eg. "12.30".
+func (m Money) String() string {
+	sign, units := "", m.Units
+	if units < 0 {
+		sign = "-"
+	}
+	// format the absolute value through uint64 so that MinInt64 does not overflow
+	abs := uint64(units)
+	if units < 0 {
+		abs = uint64(-(units + 1)) + 1
+	}
+	digits := fmt.Sprintf("%0*d", m.Scale+1, abs)
+	if m.Scale == 0 {
+		return sign + digits
+	}
+	cut := len(digits) - m.Scale
+	return sign + digits[:cut] + "." + digits[cut:]
+}
+
+// MarshalJSON encodes m as a decimal string, eg. "12.30", so that the
+// scale survives the round trip and no precision is lost to float64.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + m.String() + `"`), nil
+}
+
+// UnmarshalJSON decodes a decimal string written by MarshalJSON.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return fmt.Errorf("Malformed amount: %s", s)
+	}
+
+	parsed, err := parseDecimal(s[1 : len(s)-1])
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+// parseDecimal parses a possibly negative decimal string such as "-12.30".
+// Unlike parseMoney, the scale is taken from the number of fraction digits.
+func parseDecimal(s string) (Money, error) {
+	negative := strings.HasPrefix(s, "-")
+	if negative {
+		s = s[1:]
+	}
+	scale := 0
+	if dot := strings.IndexByte(s, '.'); dot >= 0 {
+		scale = len(s) - dot - 1
+	}
+	parsed, err := parseMoney(s, scale)
+	if err != nil {
+		return Money{}, err
+	}
+	if negative {
+		parsed = parsed.Neg()
+	}
+	return parsed, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestParseMoney(t *testing.T) {
+	type args struct {
+		s     string
+		scale int
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    Money
+		wantErr bool
+	}{{name: "integer",
+		args: args{s: "10", scale: 2},
+		want: Money{Units: 1000, Scale: 2}}, {
+		name: "decimal",
+		args: args{s: "12.34", scale: 2},
+		want: Money{Units: 1234, Scale: 2}}, {
+		name: "short fraction",
+		args: args{s: "0.5", scale: 2},
+		want: Money{Units: 50, Scale: 2}}, {
+		name: "zero",
+		args: args{s: "0", scale: 2},
+		want: Money{Units: 0, Scale: 2}}, {
+		name:    "negative",
+		args:    args{s: "-1", scale: 2},
+		wantErr: true}, {
+		name:    "plus sign",
+		args:    args{s: "+1", scale: 2},
+		wantErr: true}, {
+		name:    "too many decimals",
+		args:    args{s: "1.234", scale: 2},
+		wantErr: true}, {
+		name:    "trailing dot",
+		args:    args{s: "1.", scale: 2},
+		wantErr: true}, {
+		name:    "leading dot",
+		args:    args{s: ".5", scale: 2},
+		wantErr: true}, {
+		name:    "leading zeros",
+		args:    args{s: "007", scale: 2},
+		wantErr: true}, {
+		name:    "exponent",
+		args:    args{s: "1e3", scale: 2},
+		wantErr: true}, {
+		name:    "empty",
+		args:    args{s: "", scale: 2},
+		wantErr: true}, {
+		name:    "overflow",
+		args:    args{s: "92233720368547758.08", scale: 2},
+		wantErr: true}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseMoney(tt.args.s, tt.args.scale)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseMoney() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseMoney() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMoney_Arithmetic(t *testing.T) {
+	a := Money{Units: 1234, Scale: 2}
+	b := Money{Units: 66, Scale: 2}
+
+	if sum, err := a.Add(b); err != nil || sum.String() != "13.00" {
+		t.Errorf("Money.Add() = %v, %v, want 13.00", sum, err)
+	}
+	if diff, err := b.Sub(a); err != nil || diff.String() != "-11.68" {
+		t.Errorf("Money.Sub() = %v, %v, want -11.68", diff, err)
+	}
+	if _, err := (Money{Units: math.MaxInt64, Scale: 2}).Add(b); err == nil {
+		t.Errorf("Money.Add() expected overflow error")
+	}
+	if _, err := a.Add(Money{Units: 1, Scale: 3}); err == nil {
+		t.Errorf("Money.Add() expected scale mismatch error")
+	}
+	if got := a.Cmp(Money{Units: 12340, Scale: 3}); got != 0 {
+		t.Errorf("Money.Cmp(12.340) = %d, want 0", got)
+	}
+	if got := a.Cmp(Money{Units: 12, Scale: 0}); got != 1 {
+		t.Errorf("Money.Cmp(12) = %d, want 1", got)
+	}
+	if got := b.Cmp(Money{Units: 661, Scale: 3}); got != -1 {
+		t.Errorf("Money.Cmp(0.661) = %d, want -1", got)
+	}
+	if got := (Money{Units: math.MinInt64, Scale: 2}).String(); got != "-92233720368547758.08" {
+		t.Errorf("Money.String() = %v", got)
+	}
+	if got := (Money{Units: 5, Scale: 0}).String(); got != "5" {
+		t.Errorf("Money.String() = %v", got)
+	}
+}
+
+func TestMoney_JSON(t *testing.T) {
+	tests := []struct {
+		name string
+		m    Money
+		want string
+	}{{name: "positive", m: Money{Units: 1230, Scale: 2}, want: `"12.30"`},
+		{name: "negative", m: Money{Units: -5, Scale: 2}, want: `"-0.05"`},
+		{name: "integer", m: Money{Units: 7, Scale: 0}, want: `"7"`}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.m)
+			if err != nil || string(data) != tt.want {
+				t.Errorf("json.Marshal() = %s, %v, want %s", data, err, tt.want)
+				return
+			}
+			var got Money
+			if err := json.Unmarshal(data, &got); err != nil || got != tt.m {
+				t.Errorf("json.Unmarshal() = %v, %v, want %v", got, err, tt.m)
+			}
+		})
+	}
+}
+
+func TestMoney_MulRat(t *testing.T) {
+	tests := []struct {
+		name  string
+		m     Money
+		rate  *big.Rat
+		scale int
+		want  string
+	}{{name: "exact", m: Money{Units: 1000, Scale: 2}, rate: big.NewRat(14, 100), scale: 2, want: "1.40"},
+		{name: "half up", m: Money{Units: 1, Scale: 0}, rate: big.NewRat(5, 1000), scale: 2, want: "0.01"},
+		{name: "half down", m: Money{Units: 1, Scale: 0}, rate: big.NewRat(49, 10000), scale: 2, want: "0.00"},
+		{name: "negative", m: Money{Units: -1, Scale: 0}, rate: big.NewRat(5, 1000), scale: 2, want: "-0.01"},
+		{name: "to integer", m: Money{Units: 1000, Scale: 2}, rate: big.NewRat(3, 2), scale: 0, want: "15"}}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.m.MulRat(tt.rate, tt.scale)
+			if err != nil || got.String() != tt.want {
+				t.Errorf("Money.MulRat() = %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+}
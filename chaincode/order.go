@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// standing order status values
+const (
+	orderActive    = "active"
+	orderCancelled = "cancelled"
+	orderFinished  = "finished"
+)
+
+// orderSchedules maps the supported schedules to their period in years, months and days.
+var orderSchedules = map[string][3]int{
+	"daily":   {0, 0, 1},
+	"weekly":  {0, 0, 7},
+	"monthly": {0, 1, 0},
+	"yearly":  {1, 0, 0},
+}
+
+// standingOrder is the JSON document stored under the composite key ["order", account, id],
+// a recurring transfer from the account to the credit account. The id is the txID that
+// created it. The n-th run is due at First plus n periods, so the schedule never drifts.
+type standingOrder struct {
+	ID        string `json:"id"`
+	Account   string `json:"account"`
+	Credit    string `json:"credit"`
+	Amount    Money  `json:"amount"`
+	Schedule  string `json:"schedule"`
+	First     string `json:"first"`
+	End       string `json:"end,omitempty"`
+	Runs      int    `json:"runs"`
+	NextRun   string `json:"nextRun"`
+	Status    string `json:"status"`
+	Failures  int    `json:"failures"`
+	LastRun   string `json:"lastRun,omitempty"`
+	LastTxID  string `json:"lastTxID,omitempty"`
+	LastError string `json:"lastError,omitempty"`
+	CreatedAt string `json:"createdAt"`
+}
+
+// orderResult is the outcome of one standing order in a run of runOrders.
+type orderResult struct {
+	Order   string `json:"order"`
+	Account string `json:"account"`
+	Credit  string `json:"credit"`
+	Amount  Money  `json:"amount"`
+	Status  string `json:"status"` // "executed", "failed" or "deferred"
+	Error   string `json:"error,omitempty"`
+}
+
+// orderRun is the JSON payload returned by runOrders.
+type orderRun struct {
+	Executed int           `json:"executed"`
+	Failed   int           `json:"failed"`
+	Deferred int           `json:"deferred"`
+	Results  []orderResult `json:"results"`
+}
+
+// parseDateTime parses a date such as "2019-07-01", or an RFC3339 time.
+func parseDateTime(s string) (time.Time, error) {
+	tm, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		if tm, err = time.Parse("2006-01-02", s); err != nil {
+			return tm, fmt.Errorf("Invalid time: %s, expecting 2006-01-02 or RFC3339", s)
+		}
+	}
+	return tm.UTC(), nil
+}
+
+// advance schedules the next run of an order after a run, or finishes it after its end.
+func (o *standingOrder) advance() error {
+	first, err := time.Parse(time.RFC3339, o.First)
+	if err != nil {
+		return fmt.Errorf("Corrupted standing order: %s with error: %s", o.ID, err)
+	}
+	period := orderSchedules[o.Schedule]
+	o.Runs++
+	o.NextRun = first.AddDate(period[0]*o.Runs, period[1]*o.Runs, period[2]*o.Runs).Format(time.RFC3339)
+	if o.End != "" && o.NextRun > o.End {
+		o.Status = orderFinished
+	}
+	return nil
+}
+
+// getOrder reads a standing order of an account.
+func getOrder(stub shim.ChaincodeStubInterface, account, id string) (*standingOrder, error) {
+	key, err := stub.CreateCompositeKey("order", []string{account, id})
+	if err != nil {
+		return nil, fmt.Errorf("Create order key failed! With error: %s", err)
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get standing order: %s with error: %s", id, err)
+	}
+	if value == nil {
+		return nil, fmt.Errorf("Standing order not found: %s on account %s", id, account)
+	}
+
+	o := new(standingOrder)
+	if err := json.Unmarshal(value, o); err != nil {
+		return nil, fmt.Errorf("Corrupted standing order: %s with error: %s", id, err)
+	}
+	return o, nil
+}
+
+// putOrder writes a standing order.
+func putOrder(stub shim.ChaincodeStubInterface, o *standingOrder) error {
+	key, err := stub.CreateCompositeKey("order", []string{o.Account, o.ID})
+	if err != nil {
+		return fmt.Errorf("Create order key failed! With error: %s", err)
+	}
+	value, err := json.Marshal(o)
+	if err != nil {
+		return fmt.Errorf("Encode standing order failed! With error: %s", err)
+	}
+	if err := stub.PutState(key, value); err != nil {
+		return fmt.Errorf("Store standing order failed! With error: %s", err)
+	}
+	return nil
+}
+
+// listOrders reads the standing orders of an account, or of every account if it is empty.
+func listOrders(stub shim.ChaincodeStubInterface, account string) ([]standingOrder, error) {
+	attributes := []string{}
+	if account != "" {
+		attributes = append(attributes, account)
+	}
+	it, err := stub.GetStateByPartialCompositeKey("order", attributes)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get by partial composite key! With error: %s", err)
+	}
+	defer it.Close()
+
+	list := []standingOrder{}
+	for it.HasNext() {
+		item, err := it.Next()
+		if err != nil {
+			return nil, fmt.Errorf("Get next of iterator failed! With error: %s", err)
+		}
+		var o standingOrder
+		if err := json.Unmarshal(item.GetValue(), &o); err != nil {
+			return nil, fmt.Errorf("Decode standing order failed! With error: %s", err)
+		}
+		list = append(list, o)
+	}
+	return list, nil
+}
+
+// create a standing order
+// args[0] represents the debit account
+// args[1] represents the full credit account
+// args[2] represents the amount, in the currency of the debit account
+// args[3] represents the schedule, that is, "daily", "weekly", "monthly" or "yearly"
+// args[4] represents the first run, a date or an RFC3339 time; "*" runs it from now on
+// args[5] optionally represents the end, the last day or an RFC3339 time after which it stops
+func createOrder(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	if args[0] == args[1] {
+		return "", fmt.Errorf("Cannot order a transfer to the same account: %s", args[0])
+	}
+	acc, err := getAccount(stub, args[0])
+	if err != nil {
+		return "", err
+	}
+	if _, err := getAccount(stub, args[1]); err != nil {
+		return "", fmt.Errorf("Invalid credit account! With error: %s", err)
+	}
+	amount, err := parseAmount(args[2], acc.Balance.Scale)
+	if err != nil {
+		return "", fmt.Errorf("Invalid amount! With Error: %s", err)
+	}
+	if _, ok := orderSchedules[args[3]]; !ok {
+		return "", fmt.Errorf("Unsupported schedule: %s, expecting daily, weekly, monthly or yearly", args[3])
+	}
+	if err := checkThreshold(stub, args[0], amount, "Standing order"); err != nil {
+		return "", err
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+	first := now
+	if args[4] != anyBound {
+		if first, err = parseDateTime(args[4]); err != nil {
+			return "", err
+		}
+	}
+	o := &standingOrder{
+		ID:        stub.GetTxID(),
+		Account:   args[0],
+		Credit:    args[1],
+		Amount:    amount,
+		Schedule:  args[3],
+		First:     first.Format(time.RFC3339),
+		NextRun:   first.Format(time.RFC3339),
+		Status:    orderActive,
+		CreatedAt: now.Format(time.RFC3339),
+	}
+	if len(args) > 5 && args[5] != anyBound {
+		end, err := parseDateTime(args[5])
+		if err != nil {
+			return "", err
+		}
+		// a date ends the order at the end of that day
+		if len(args[5]) == len("2006-01-02") {
+			end = end.AddDate(0, 0, 1).Add(-time.Second)
+		}
+		if end.Before(first) {
+			return "", fmt.Errorf("The end %s is before the first run %s!", args[5], o.First)
+		}
+		o.End = end.Format(time.RFC3339)
+	}
+
+	if err := putOrder(stub, o); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Create standing order is success! Order: %s; Account: %s; Credit: %s; Amount: %s; Schedule: %s; First run: %s",
+		o.ID, o.Account, o.Credit, o.Amount, o.Schedule, o.First), nil
+}
+
+// cancel a standing order
+// args[0] represents the debit account
+// args[1] represents the order id
+func cancelOrder(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	o, err := getOrder(stub, args[0], args[1])
+	if err != nil {
+		return "", err
+	}
+	if o.Status != orderActive {
+		return "", fmt.Errorf("Standing order %s is already %s!", o.ID, o.Status)
+	}
+
+	o.Status = orderCancelled
+	if err := putOrder(stub, o); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Cancel standing order is success! Order: %s", o.ID), nil
+}
+
+// list the standing orders of an account
+// args[0] represents the full account
+func orders(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	list, err := listOrders(stub, args[0])
+	if err != nil {
+		return "", err
+	}
+
+	result, err := json.Marshal(list)
+	if err != nil {
+		return "", fmt.Errorf("Encode standing orders failed! With error: %s", err)
+	}
+	return string(result), nil
+}
+
+// execute every standing order due as of the transaction timestamp.
+// Each due order makes one transfer; an order that fails, eg. for lack of funds,
+// records the failure and moves on to its next run without aborting the others.
+// A credit account is paid once per run, as the history keeps one entry per
+// transaction and pair of accounts; a second order paying it is deferred to the next run.
+func runOrders(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	tm, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+	now := tm.Format(time.RFC3339)
+	list, err := listOrders(stub, "")
+	if err != nil {
+		return "", err
+	}
+
+	buffered := newTxStub(stub)
+	run := orderRun{Results: []orderResult{}}
+	paid := make(map[string]bool)
+	var legs []eventLeg
+	for i := range list {
+		o := &list[i]
+		if o.Status != orderActive || o.NextRun > now {
+			continue
+		}
+		result := orderResult{Order: o.ID, Account: o.Account, Credit: o.Credit, Amount: o.Amount}
+		if paid[o.Credit] {
+			result.Status = "deferred"
+			run.Deferred++
+			run.Results = append(run.Results, result)
+			continue
+		}
+
+		// a failed transfer leaves nothing behind but the failure,
+		// the threshold may have been lowered since the order was created
+		savepoint := newTxStub(buffered)
+		var moved *movement
+		err := checkThreshold(savepoint, o.Account, o.Amount, "Standing order")
+		if err == nil {
+			moved, err = moveFunds(savepoint, o.Account, o.Credit, o.Amount.String(), nil, nil)
+		}
+		if err == nil {
+			err = savepoint.flush()
+		}
+		if err != nil {
+			result.Status, result.Error = "failed", err.Error()
+			o.Failures++
+			o.LastError = err.Error()
+			run.Failed++
+		} else {
+			result.Status = "executed"
+			o.LastError = ""
+			paid[o.Credit] = true
+			legs = append(legs, moved.legs()...)
+			run.Executed++
+		}
+		o.LastRun = now
+		o.LastTxID = stub.GetTxID()
+		if err := o.advance(); err != nil {
+			return "", err
+		}
+		if err := putOrder(buffered, o); err != nil {
+			return "", err
+		}
+		run.Results = append(run.Results, result)
+	}
+
+	if err := buffered.flush(); err != nil {
+		return "", fmt.Errorf("Store standing orders failed! With error: %s", err)
+	}
+	if err := emitEvent(stub, "runorders", legs...); err != nil {
+		return "", err
+	}
+
+	result, err := json.Marshal(run)
+	if err != nil {
+		return "", fmt.Errorf("Encode standing order run failed! With error: %s", err)
+	}
+	return string(result), nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// runOrdersAt executes the standing orders due at a time and decodes the run.
+func (l *testLedger) runOrdersAt(at string) orderRun {
+	l.t.Helper()
+	tm, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		l.t.Fatalf("Invalid time: %s", at)
+	}
+	result, err := l.call(tm, runOrders)
+	if err != nil {
+		l.t.Fatalf("runorders failed: %s", err)
+	}
+	var run orderRun
+	if err := json.Unmarshal([]byte(result), &run); err != nil {
+		l.t.Fatalf("Decode standing order run failed! With error: %s", err)
+	}
+	return run
+}
+
+func TestStandingOrders(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "100")
+	l.open(l.anz, "carol", "0")
+	l.open(l.citi, "bob", "0")
+
+	l.fail(l.anz, "Cannot order a transfer to the same account: alice@ANZBank", "order", "alice", "alice@ANZBank", "1", "daily", "*")
+	l.fail(l.anz, "Unsupported schedule: hourly", "order", "alice", "bob@CitiBank", "1", "hourly", "*")
+	l.fail(l.anz, "The end 2029-12-31 is before the first run", "order", "alice", "bob@CitiBank", "1", "daily", "2030-01-01", "2029-12-31")
+	l.ok(l.anz, "order", "alice", "bob@CitiBank", "30", "daily", "2030-01-01", "2030-01-02")
+	rent := l.lastTx()
+	l.ok(l.anz, "order", "alice", "bob@CitiBank", "10", "daily", "2030-01-01")
+	l.ok(l.anz, "order", "carol", "alice@ANZBank", "5", "daily", "2030-01-01")
+	broke := l.lastTx()
+
+	check := func(at string, executed, failed, deferred int) {
+		t.Helper()
+		if run := l.runOrdersAt(at); run.Executed != executed || run.Failed != failed || run.Deferred != deferred {
+			t.Errorf("run at %s = %+v, want %d executed, %d failed and %d deferred", at, run, executed, failed, deferred)
+		}
+	}
+	// bob is paid once a run, so the second order waits for the next one
+	check("2030-01-01T12:00:00Z", 1, 1, 1)
+	check("2030-01-01T12:00:00Z", 1, 0, 0)
+	// the rent is paid for the last time, carol still has no money
+	check("2030-01-02T12:00:00Z", 1, 1, 1)
+	l.ok(l.anz, "cancelorder", "carol", broke)
+	l.fail(l.anz, "Standing order "+broke+" is already cancelled!", "cancelorder", "carol", broke)
+	check("2030-01-03T12:00:00Z", 1, 0, 0)
+
+	var list []standingOrder
+	if err := json.Unmarshal([]byte(l.ok(l.anz, "orders", "alice")), &list); err != nil {
+		t.Fatalf("Decode standing orders failed! With error: %s", err)
+	}
+	if len(list) != 2 || list[0].ID != rent || list[0].Status != orderFinished || list[0].Runs != 2 {
+		t.Errorf("orders of alice = %+v, want the rent finished after 2 runs", list)
+	}
+	if got := l.balance("alice@ANZBank"); got != "20.00" {
+		t.Errorf("balance of alice = %s, want 20.00", got)
+	}
+	if got := l.balance("bob@CitiBank"); got != "80.00" {
+		t.Errorf("balance of bob = %s, want 80.00", got)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+const (
+	// roleAttribute is the certificate attribute that carries the role of a client
+	roleAttribute = "role"
+	// roleBankAdmin may change every account of its bank, whoever owns it
+	roleBankAdmin = "bankadmin"
+	// roleTeller takes in the deposits of the customers of its bank
+	roleTeller = "teller"
+	// roleApprover signs off the pending payments of its bank
+	roleApprover = "approver"
+)
+
+// checkOwner allows a client to change an account if it owns the account, or if it is a bank admin.
+// Accounts created before ownership was recorded have no owner and only bank admins may change them.
+func checkOwner(stub shim.ChaincodeStubInterface, account, id string, admin bool) error {
+	if admin {
+		return nil
+	}
+	acc, err := getAccount(stub, account)
+	if err != nil {
+		return err
+	}
+	if acc.OwnerID == "" || acc.OwnerID != id {
+		return fmt.Errorf("You do not own the account: %s", account)
+	}
+	return nil
+}
+
+// transfer the ownership of an account to another client identity
+// args[0] represents the full account
+// args[1] represents the identity (cid GetID) of the new owner
+func chown(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	acc, err := getAccount(stub, args[0])
+	if err != nil {
+		return "", err
+	}
+	if args[1] == "" {
+		return "", fmt.Errorf("The new owner must not be empty!")
+	}
+
+	acc.OwnerID = args[1]
+	if err := putAccount(stub, args[0], acc); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Chown is success! Account: %s; Owner: %s", args[0], args[1]), nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestOnlyTheOwnerMovesMoney(t *testing.T) {
+	l := newLedger(t)
+	other := newClient(t, "ANZBankMSP", "anz-user2", nil)
+	l.open(l.anz, "alice", "100")
+	l.open(other, "carol", "0")
+	l.open(l.citi, "bob", "0")
+
+	// a customer of the same bank is not the owner, a bank admin acts for any owner
+	l.fail(other, "You do not own the account: alice@ANZBank", "transfer", "alice", "bob@CitiBank", "10")
+	l.fail(other, "You do not own the account: alice@ANZBank", "reduce", "alice", "10")
+	l.fail(other, "You do not own the account: alice@ANZBank", "chown", "alice", l.account("carol@ANZBank").OwnerID)
+	l.ok(l.anzAdmin, "transfer", "alice", "bob@CitiBank", "10")
+
+	// the owner hands the account over
+	l.ok(l.anz, "chown", "alice", l.account("carol@ANZBank").OwnerID)
+	l.fail(l.anz, "You do not own the account: alice@ANZBank", "transfer", "alice", "bob@CitiBank", "10")
+	l.ok(other, "transfer", "alice", "bob@CitiBank", "10")
+
+	if got := l.balance("alice@ANZBank"); got != "80.00" {
+		t.Errorf("balance of alice = %s, want 80.00", got)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// accessPolicy is the JSON document stored under the composite key ["policy", function].
+// Only clients whose certificate carries one of the Roles in roleAttribute may call the function,
+// on top of the MSP checks of Invoke. A function without a policy is open to every role.
+type accessPolicy struct {
+	Function  string   `json:"function"`
+	Roles     []string `json:"roles"`
+	UpdatedBy string   `json:"updatedBy"`
+	UpdatedAt string   `json:"updatedAt"`
+}
+
+// readPolicy reads the policy of a function, it returns nil if the function has none.
+func readPolicy(stub shim.ChaincodeStubInterface, fn string) (*accessPolicy, error) {
+	key, err := stub.CreateCompositeKey("policy", []string{fn})
+	if err != nil {
+		return nil, fmt.Errorf("Create policy key failed! With error: %s", err)
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get policy: %s with error: %s", fn, err)
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	policy := new(accessPolicy)
+	if err := json.Unmarshal(value, policy); err != nil {
+		return nil, fmt.Errorf("Corrupted policy: %s with error: %s", fn, err)
+	}
+	return policy, nil
+}
+
+// checkPolicy allows a client to call a function if its role satisfies the policy of the function.
+// setpolicy itself is never restricted, so that the supervisor cannot lock itself out.
+func checkPolicy(stub shim.ChaincodeStubInterface, client cid.ClientIdentity, fn string) error {
+	if fn == "setpolicy" {
+		return nil
+	}
+	policy, err := readPolicy(stub, fn)
+	if err != nil || policy == nil {
+		return err
+	}
+
+	role, _, err := client.GetAttributeValue(roleAttribute)
+	if err != nil {
+		return fmt.Errorf("Get client role failed! With error: %s", err)
+	}
+	for _, allowed := range policy.Roles {
+		if role == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("The role %q is not allowed to call %s! Allowed roles: %s", role, fn, strings.Join(policy.Roles, ","))
+}
+
+// the supervisor limits a function to some roles
+// args[0] represents the function
+// args[1] represents the comma separated roles, eg. "teller,bankadmin"; "*" removes the policy
+// args[2] represents the identity of the supervisor
+func setPolicy(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	if _, ok := paramLength[args[0]]; !ok || args[0] == "setpolicy" {
+		return "", fmt.Errorf("Cannot set the policy of function: %s", args[0])
+	}
+	key, err := stub.CreateCompositeKey("policy", []string{args[0]})
+	if err != nil {
+		return "", fmt.Errorf("Create policy key failed! With error: %s", err)
+	}
+
+	if args[1] == anyBound {
+		if err := stub.DelState(key); err != nil {
+			return "", fmt.Errorf("Delete policy failed! With error: %s", err)
+		}
+		return fmt.Sprintf("Set policy is success! Function %s is open to every role", args[0]), nil
+	}
+
+	// keep each role once, in a stable order
+	seen := make(map[string]bool)
+	var roles []string
+	for _, role := range strings.Split(args[1], ",") {
+		role = strings.TrimSpace(role)
+		if role != "" && !seen[role] {
+			seen[role] = true
+			roles = append(roles, role)
+		}
+	}
+	if len(roles) == 0 {
+		return "", fmt.Errorf("Expecting at least one role, or \"*\" to remove the policy!")
+	}
+	sort.Strings(roles)
+
+	tm, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+	value, err := json.Marshal(accessPolicy{
+		Function:  args[0],
+		Roles:     roles,
+		UpdatedBy: args[2],
+		UpdatedAt: tm.Format(time.RFC3339),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Encode policy failed! With error: %s", err)
+	}
+	if err := stub.PutState(key, value); err != nil {
+		return "", fmt.Errorf("Store policy failed! With error: %s", err)
+	}
+	return fmt.Sprintf("Set policy is success! Function %s is limited to roles: %s", args[0], strings.Join(roles, ",")), nil
+}
+
+// list the policies of every restricted function
+func policies(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	it, err := stub.GetStateByPartialCompositeKey("policy", []string{})
+	if err != nil {
+		return "", fmt.Errorf("Cannot get by partial composite key! With error: %s", err)
+	}
+	defer it.Close()
+
+	list := []accessPolicy{}
+	for it.HasNext() {
+		item, err := it.Next()
+		if err != nil {
+			return "", fmt.Errorf("Get next of iterator failed! With error: %s", err)
+		}
+		var policy accessPolicy
+		if err := json.Unmarshal(item.GetValue(), &policy); err != nil {
+			return "", fmt.Errorf("Decode policy failed! With error: %s", err)
+		}
+		list = append(list, policy)
+	}
+
+	result, err := json.Marshal(list)
+	if err != nil {
+		return "", fmt.Errorf("Encode policies failed! With error: %s", err)
+	}
+	return string(result), nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPolicyLimitsFunctionsToRoles(t *testing.T) {
+	l := newLedger(t)
+	teller := newClient(t, "ANZBankMSP", "anz-teller", map[string]string{roleAttribute: roleTeller})
+	l.open(l.anz, "alice", "100")
+
+	l.fail(l.anzAdmin, "You do not have authority to get access to this function!", "setpolicy", "add", "bankadmin")
+	l.fail(l.supervisor, "Cannot set the policy of function: setpolicy", "setpolicy", "setpolicy", "bankadmin")
+	l.fail(l.supervisor, "Cannot set the policy of function: mint", "setpolicy", "mint", "bankadmin")
+	l.fail(l.supervisor, "Expecting at least one role", "setpolicy", "add", " , ")
+
+	// only bank admins deposit from now on, not even tellers
+	l.ok(l.supervisor, "setpolicy", "add", "bankadmin, bankadmin")
+	l.fail(teller, `The role "teller" is not allowed to call add! Allowed roles: bankadmin`, "add", "alice", "10")
+	l.fail(l.anz, `The role "" is not allowed to call add! Allowed roles: bankadmin`, "add", "alice", "10")
+	l.ok(l.anzAdmin, "add", "alice", "10")
+
+	var list []accessPolicy
+	if err := json.Unmarshal([]byte(l.ok(l.anz, "policies")), &list); err != nil {
+		t.Fatalf("Decode policies failed! With error: %s", err)
+	}
+	if len(list) != 1 || list[0].Function != "add" || len(list[0].Roles) != 1 || list[0].Roles[0] != roleBankAdmin {
+		t.Errorf("policies = %+v, want add limited to bankadmin", list)
+	}
+
+	l.ok(l.supervisor, "setpolicy", "add", "*")
+	l.ok(teller, "add", "alice", "10")
+	if got := l.balance("alice@ANZBank"); got != "120.00" {
+		t.Errorf("balance of alice = %s, want 120.00", got)
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// remittance status values, a pending remittance past its expiry reads as expired until it is swept
+const (
+	remitPending  = "pending"
+	remitAccepted = "accepted"
+	remitRejected = "rejected"
+	remitExpired  = "expired"
+)
+
+// remittance is the JSON document stored under the composite key ["remit", id],
+// a cross-bank transfer waiting for the credit bank to accept it.
+// Until then its funds, with the fee known when it was sent, are held on the debit account
+// by the hold of the same id; accepting captures the hold, rejecting or expiring releases it.
+// Fee is that fee, the acceptance charges it whatever the fee schedule says by then.
+type remittance struct {
+	ID        string `json:"id"`
+	Debit     string `json:"debit"`
+	Credit    string `json:"credit"`
+	Amount    Money  `json:"amount"`
+	Fee       Money  `json:"fee"`
+	Currency  string `json:"currency"`
+	Status    string `json:"status"`
+	Expiry    string `json:"expiry"`
+	CreatedAt string `json:"createdAt"`
+	ClosedAt  string `json:"closedAt,omitempty"`
+	ClosedBy  string `json:"closedBy,omitempty"` // txID of the acceptance, rejection or sweep
+	Reason    string `json:"reason,omitempty"`
+}
+
+// getRemittance reads a remittance.
+func getRemittance(stub shim.ChaincodeStubInterface, id string) (*remittance, error) {
+	key, err := stub.CreateCompositeKey("remit", []string{id})
+	if err != nil {
+		return nil, fmt.Errorf("Create remittance key failed! With error: %s", err)
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get remittance: %s with error: %s", id, err)
+	}
+	if value == nil {
+		return nil, fmt.Errorf("Remittance not found: %s", id)
+	}
+
+	r := new(remittance)
+	if err := json.Unmarshal(value, r); err != nil {
+		return nil, fmt.Errorf("Corrupted remittance: %s with error: %s", id, err)
+	}
+	return r, nil
+}
+
+// putRemittance writes a remittance.
+func putRemittance(stub shim.ChaincodeStubInterface, r *remittance) error {
+	key, err := stub.CreateCompositeKey("remit", []string{r.ID})
+	if err != nil {
+		return fmt.Errorf("Create remittance key failed! With error: %s", err)
+	}
+	value, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("Encode remittance failed! With error: %s", err)
+	}
+	if err := stub.PutState(key, value); err != nil {
+		return fmt.Errorf("Store remittance failed! With error: %s", err)
+	}
+	return nil
+}
+
+// listRemittances reads every remittance, from the oldest to the newest txID.
+func listRemittances(stub shim.ChaincodeStubInterface) ([]remittance, error) {
+	list := []remittance{}
+	err := scanRecords(stub, "remit", func(value []byte) error {
+		var r remittance
+		if err := json.Unmarshal(value, &r); err != nil {
+			return fmt.Errorf("Decode remittance failed! With error: %s", err)
+		}
+		list = append(list, r)
+		return nil
+	})
+	return list, err
+}
+
+// answerRemittance reads a pending remittance of the credit bank and its hold,
+// which must not have expired yet.
+func answerRemittance(stub shim.ChaincodeStubInterface, id, bank string) (*remittance, *hold, time.Time, error) {
+	tm, err := txTime(stub)
+	if err != nil {
+		return nil, nil, tm, err
+	}
+	r, err := getRemittance(stub, id)
+	if err != nil {
+		return nil, nil, tm, err
+	}
+	if bankOf(r.Credit) != bank {
+		return nil, nil, tm, fmt.Errorf("Only %s can answer remittance %s!", bankOf(r.Credit), id)
+	}
+	if r.Status != remitPending {
+		return nil, nil, tm, fmt.Errorf("Remittance %s is already %s!", id, r.Status)
+	}
+	h, err := getHold(stub, r.Debit, r.ID)
+	if err != nil {
+		return nil, nil, tm, err
+	}
+	if !h.live(tm.Format(time.RFC3339)) {
+		return nil, nil, tm, fmt.Errorf("Remittance %s has expired at %s!", id, r.Expiry)
+	}
+	return r, h, tm, nil
+}
+
+// closeRemittance closes a remittance and its hold, without moving any money unless accepted.
+func closeRemittance(stub shim.ChaincodeStubInterface, r *remittance, h *hold, status, reason string, tm time.Time) error {
+	r.Status = status
+	r.Reason = reason
+	r.ClosedAt = tm.Format(time.RFC3339)
+	r.ClosedBy = stub.GetTxID()
+	if err := putRemittance(stub, r); err != nil {
+		return err
+	}
+
+	h.Status = holdReleased
+	if status == remitAccepted {
+		h.Status = holdCaptured
+		h.Captured = r.Amount
+	}
+	h.ClosedAt = r.ClosedAt
+	h.ClosedBy = r.ClosedBy
+	return putHold(stub, h)
+}
+
+// send an amount to an account at another bank, which has to accept it
+// args[0] represents the debit account
+// args[1] represents the full credit account, it is only checked by the credit bank
+// args[2] represents the amount, in the currency of the debit account
+// args[3] represents how long the credit bank has to answer, a duration such as "72h" or an RFC3339 time
+func remit(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	if bankOf(args[0]) == bankOf(args[1]) {
+		return "", fmt.Errorf("Cannot remit within the bank, use transfer: %s", args[1])
+	}
+	if isSystemAccount(args[1]) {
+		return "", fmt.Errorf("Cannot remit to the system account: %s", args[1])
+	}
+	acc, err := getAccount(stub, args[0])
+	if err != nil {
+		return "", err
+	}
+	if err := checkUsable(args[0], acc); err != nil {
+		return "", err
+	}
+	amount, err := parseAmount(args[2], acc.Balance.Scale)
+	if err != nil {
+		return "", fmt.Errorf("Invalid amount! With Error: %s", err)
+	}
+
+	// the fee is held with the amount, so that the acceptance can pay it
+	fee, err := transferFee(stub, args[0], args[1], acc, amount)
+	if err != nil {
+		return "", err
+	}
+	held, err := amount.Add(fee)
+	if err != nil {
+		return "", err
+	}
+	available, err := availableFunds(stub, args[0], acc)
+	if err != nil {
+		return "", err
+	}
+	if held.Cmp(available) > 0 {
+		return "", fmt.Errorf("The balance in %s's account is not enough to remit!", args[0])
+	}
+	if err := checkThreshold(stub, args[0], amount, "Remittance"); err != nil {
+		return "", err
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+	expiry, err := parseExpiry(args[3], now)
+	if err != nil {
+		return "", err
+	}
+
+	r := &remittance{
+		ID:        stub.GetTxID(),
+		Debit:     args[0],
+		Credit:    args[1],
+		Amount:    amount,
+		Fee:       fee,
+		Currency:  acc.Currency,
+		Status:    remitPending,
+		Expiry:    expiry.Format(time.RFC3339),
+		CreatedAt: now.Format(time.RFC3339),
+	}
+	if err := putRemittance(stub, r); err != nil {
+		return "", err
+	}
+	if err := putHold(stub, &hold{
+		ID:          r.ID,
+		Account:     r.Debit,
+		Beneficiary: r.Credit,
+		Amount:      held,
+		Captured:    Money{Scale: held.Scale},
+		Expiry:      r.Expiry,
+		Status:      holdActive,
+		CreatedAt:   r.CreatedAt,
+		Remittance:  true,
+	}); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Remit is success! Remittance: %s; Amount: %s %s; Fee: %s; Held: %s; Expiry: %s",
+		r.ID, amount, r.Currency, fee, held, r.Expiry), nil
+}
+
+// the credit bank accepts a remittance, which makes the transfer
+// args[0] represents the remittance id
+// args[1] represents the bank of the caller
+func acceptRemit(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	r, h, tm, err := answerRemittance(stub, args[0], args[1])
+	if err != nil {
+		return "", err
+	}
+
+	// the rule may have changed since the remittance was sent
+	if err := checkThreshold(stub, r.Debit, r.Amount, "Remittance"); err != nil {
+		return "", err
+	}
+	// the hold is released to the transfer, which pays the fee it held for
+	moved, err := moveFunds(stub, r.Debit, r.Credit, r.Amount.String(), &h.Amount, &r.Fee)
+	if err != nil {
+		return "", err
+	}
+	if err := closeRemittance(stub, r, h, remitAccepted, "", tm); err != nil {
+		return "", err
+	}
+	if err := emitEvent(stub, "acceptremit", moved.legs()...); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Accept remit is success! Remittance: %s;%s", r.ID, moved), nil
+}
+
+// the credit bank rejects a remittance, the funds held for it are freed
+// args[0] represents the remittance id
+// args[1] represents the reason
+// args[2] represents the bank of the caller
+func rejectRemit(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	r, h, tm, err := answerRemittance(stub, args[0], args[2])
+	if err != nil {
+		return "", err
+	}
+	if err := closeRemittance(stub, r, h, remitRejected, args[1], tm); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Reject remit is success! Remittance: %s; Reason: %s", r.ID, r.Reason), nil
+}
+
+// close the remittances nobody answered before their expiry. Their holds already stopped
+// counting against the debit accounts when they expired, the sweep records the outcome.
+func sweepRemits(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	tm, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+	now := tm.Format(time.RFC3339)
+	list, err := listRemittances(stub)
+	if err != nil {
+		return "", err
+	}
+
+	swept := 0
+	for i := range list {
+		r := &list[i]
+		if r.Status != remitPending || now < r.Expiry {
+			continue
+		}
+		h, err := getHold(stub, r.Debit, r.ID)
+		if err != nil {
+			return "", err
+		}
+		if err := closeRemittance(stub, r, h, remitExpired, "no answer before the expiry", tm); err != nil {
+			return "", err
+		}
+		swept++
+	}
+	return fmt.Sprintf("Sweep remits is success! Expired: %d", swept), nil
+}
+
+// list the remittances sent or received by a bank
+// args[0] optionally represents the bank, every remittance is listed without it
+func remits(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	tm, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+	now := tm.Format(time.RFC3339)
+	list, err := listRemittances(stub)
+	if err != nil {
+		return "", err
+	}
+
+	filtered := []remittance{}
+	for _, r := range list {
+		if len(args) > 0 && bankOf(r.Debit) != args[0] && bankOf(r.Credit) != args[0] {
+			continue
+		}
+		if r.Status == remitPending && now >= r.Expiry {
+			r.Status = remitExpired
+		}
+		filtered = append(filtered, r)
+	}
+
+	result, err := json.Marshal(filtered)
+	if err != nil {
+		return "", fmt.Errorf("Encode remittances failed! With error: %s", err)
+	}
+	return string(result), nil
+}
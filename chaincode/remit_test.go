@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRemittanceAnsweredByTheCreditBank(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "100")
+	l.open(l.anz, "carol", "0")
+	l.open(l.citi, "bob", "0")
+
+	l.fail(l.anz, "Cannot remit within the bank, use transfer: carol@ANZBank", "remit", "alice", "carol@ANZBank", "10", "72h")
+	l.fail(l.anz, "The balance in alice@ANZBank's account is not enough to remit!", "remit", "alice", "bob@CitiBank", "100.01", "72h")
+
+	// the funds stay on the debit account, held, until the credit bank accepts
+	l.ok(l.anz, "remit", "alice", "bob@CitiBank", "60", "72h")
+	accepted := l.lastTx()
+	if got := l.ok(l.anz, "get", "alice"); !strings.Contains(got, "Held: 60.00; Available: 40.00; Balance: 100.00") {
+		t.Errorf("get = %s, want the remittance held", got)
+	}
+	l.fail(l.anz, "Only CitiBank can answer remittance "+accepted+"!", "acceptremit", accepted)
+	l.fail(l.citi, "belongs to a remittance", "release", "alice@ANZBank", accepted)
+	l.ok(l.citi, "acceptremit", accepted)
+	l.fail(l.citi, "Remittance "+accepted+" is already accepted!", "rejectremit", accepted, "too late")
+
+	l.ok(l.anz, "remit", "alice", "bob@CitiBank", "20", "72h")
+	rejected := l.lastTx()
+	l.ok(l.citi, "rejectremit", rejected, "unknown beneficiary")
+
+	// an unanswered remittance expires, and is closed by the sweep
+	l.ok(l.anz, "remit", "alice", "bob@CitiBank", "30", "1h")
+	expired := l.lastTx()
+	later := time.Now().Add(2 * time.Hour)
+	if _, err := l.call(later, acceptRemit, expired, "CitiBank"); err == nil || !strings.Contains(err.Error(), "has expired") {
+		t.Errorf("accept of an expired remittance = %v, want it expired", err)
+	}
+	if got, err := l.call(later, sweepRemits); err != nil || got != "Sweep remits is success! Expired: 1" {
+		t.Errorf("sweepremits = %s, %v, want 1 expired", got, err)
+	}
+
+	var list []remittance
+	if err := json.Unmarshal([]byte(l.ok(l.citi, "remits")), &list); err != nil {
+		t.Fatalf("Decode remittances failed! With error: %s", err)
+	}
+	statuses := make(map[string]string)
+	for _, r := range list {
+		statuses[r.ID] = r.Status + " " + r.Reason
+	}
+	if statuses[accepted] != "accepted " || statuses[rejected] != "rejected unknown beneficiary" ||
+		statuses[expired] != "expired no answer before the expiry" {
+		t.Errorf("remits = %+v, want one accepted, one rejected and one expired", list)
+	}
+	if got := l.ok(l.anz, "get", "alice"); !strings.Contains(got, "Held: 0.00; Available: 40.00; Balance: 40.00") {
+		t.Errorf("get = %s, want nothing held", got)
+	}
+	if got := l.balance("bob@CitiBank"); got != "60.00" {
+		t.Errorf("balance of bob = %s, want 60.00", got)
+	}
+}
+
+func TestRemittanceChargesTheFeeItHeld(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "100")
+	l.open(l.citi, "bob", "0")
+	l.ok(l.anzAdmin, "setfees", "cross", `[{"from":"0","flat":"1"}]`)
+
+	if got := l.ok(l.anz, "remit", "alice", "bob@CitiBank", "50", "72h"); !strings.Contains(got, "Fee: 1.00; Held: 51.00") {
+		t.Errorf("remit = %s, want the fee held with the amount", got)
+	}
+	remitted := l.lastTx()
+
+	// the fee goes up before the credit bank answers, the remittance still pays what it held
+	l.ok(l.anzAdmin, "setfees", "cross", `[{"from":"0","flat":"10"}]`)
+	if got := l.ok(l.citi, "acceptremit", remitted); !strings.Contains(got, "Fee: 1.00 CNY") {
+		t.Errorf("acceptremit = %s, want the fee of 1.00 held by the remittance", got)
+	}
+	for account, want := range map[string]string{
+		"alice@ANZBank":                  "49.00",
+		"bob@CitiBank":                   "50.00",
+		revenueAccount("ANZBank", "CNY"): "1.00",
+	} {
+		if got := l.balance(account); got != want {
+			t.Errorf("balance of %s = %s, want %s", account, got, want)
+		}
+	}
+}
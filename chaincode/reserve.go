@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// history entry types of the money moving between a bank reserve and its accounts
+const (
+	historyDeposit    = "deposit"
+	historyWithdrawal = "withdrawal"
+)
+
+// issuance is the JSON document stored under the composite key ["issue", currency, time, txID],
+// money the supervisor issued into the reserve of a bank.
+type issuance struct {
+	Bank     string `json:"bank"`
+	Currency string `json:"currency"`
+	Amount   Money  `json:"amount"`
+	IssuedBy string `json:"issuedBy"`
+	Time     string `json:"time"`
+	TxID     string `json:"txID"`
+}
+
+// supplyLine is one currency of the supply report. The money in the accounts must add up to
+// the money issued, plus what FX transfers converted into the currency, less what they converted out.
+type supplyLine struct {
+	Currency  string `json:"currency"`
+	Issued    Money  `json:"issued"`
+	FXIn      Money  `json:"fxIn"`
+	FXOut     Money  `json:"fxOut"`
+	Reserves  Money  `json:"reserves"`
+	Revenue   Money  `json:"revenue"`
+	Customers Money  `json:"customers"`
+	Balanced  bool   `json:"balanced"`
+}
+
+// reserveAccount returns the reserve of a bank in a currency, eg. "$reserve.CNY@ANZBank".
+// Only the supervisor creates money, by issuing it into a reserve; the deposits into the
+// accounts of the bank come out of its reserve and the withdrawals go back into it.
+func reserveAccount(bank, currency string) string {
+	return systemPrefix + "reserve." + currency + "@" + bank
+}
+
+// getSystemAccount reads a system account, it is opened with a zero balance on its first use.
+func getSystemAccount(stub shim.ChaincodeStubInterface, account, currency string) (*Account, error) {
+	value, err := stub.GetState(account)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get asset: %s with error: %s", account, err)
+	}
+	if value != nil {
+		return getAccount(stub, account)
+	}
+	scale, err := currencyScale(currency)
+	if err != nil {
+		return nil, err
+	}
+	return newAccount(stub, account, Money{Scale: scale}, currency)
+}
+
+// settleReserve moves an amount between an account and the reserve of its bank:
+// a positive amount is paid out of the reserve into the account, a negative one goes back.
+// Both sides get a history entry of the given type. The reserve is written here,
+// the caller writes the account back. It returns the reserve and its new state.
+func settleReserve(stub shim.ChaincodeStubInterface, account string, acc *Account, amount Money, entryType string) (string, *Account, error) {
+	if isSystemAccount(account) {
+		return "", nil, fmt.Errorf("Cannot settle the system account %s with the reserve!", account)
+	}
+	reserve := reserveAccount(bankOf(account), acc.Currency)
+	reserveAcc, err := getSystemAccount(stub, reserve, acc.Currency)
+	if err != nil {
+		return "", nil, err
+	}
+	if amount.Cmp(reserveAcc.Balance) > 0 {
+		return "", nil, fmt.Errorf("The reserve %s is not enough, it holds %s %s!", reserve, reserveAcc.Balance, acc.Currency)
+	}
+
+	if reserveAcc.Balance, err = reserveAcc.Balance.Sub(amount); err != nil {
+		return "", nil, fmt.Errorf("Settle reserve %s failed! With error: %s", reserve, err)
+	}
+	if acc.Balance, err = acc.Balance.Add(amount); err != nil {
+		return "", nil, fmt.Errorf("Settle account %s failed! With error: %s", account, err)
+	}
+	// the interest paid by the bank is no movement of the customer
+	if entryType != historyInterest {
+		if err := touch(stub, account, acc, amount.Sign() > 0); err != nil {
+			return "", nil, err
+		}
+	}
+	if err := putAccount(stub, reserve, reserveAcc); err != nil {
+		return "", nil, err
+	}
+
+	// the history entries carry the amount, the direction tells where it went
+	direction, opposite := "in", "out"
+	record := historyRecord{Type: entryType, Amount: amount, Currency: acc.Currency}
+	if amount.Sign() < 0 {
+		direction, opposite = "out", "in"
+		record.Amount = amount.Neg()
+	}
+	if err := addHistory(stub, direction, account, reserve, record); err != nil {
+		return "", nil, err
+	}
+	if err := addHistory(stub, opposite, reserve, account, record); err != nil {
+		return "", nil, err
+	}
+	return reserve, reserveAcc, nil
+}
+
+// the supervisor issues money into the reserve of a bank
+// args[0] represents the bank, eg. "ANZBank"
+// args[1] represents the currency
+// args[2] represents the amount
+// args[3] represents the identity of the supervisor
+func issue(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	if args[0] == "" || strings.ContainsAny(args[0], "@"+systemPrefix) {
+		return "", fmt.Errorf("Invalid bank: %s", args[0])
+	}
+	reserve := reserveAccount(args[0], args[1])
+	acc, err := getSystemAccount(stub, reserve, args[1])
+	if err != nil {
+		return "", err
+	}
+	amount, err := parseAmount(args[2], acc.Balance.Scale)
+	if err != nil {
+		return "", fmt.Errorf("Invalid amount! With Error: %s", err)
+	}
+
+	if acc.Balance, err = acc.Balance.Add(amount); err != nil {
+		return "", fmt.Errorf("Failed to add to asset: %s with error: %s", reserve, err)
+	}
+	if err := putAccount(stub, reserve, acc); err != nil {
+		return "", err
+	}
+
+	tm, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+	record := issuance{
+		Bank:     args[0],
+		Currency: args[1],
+		Amount:   amount,
+		IssuedBy: args[3],
+		Time:     tm.Format(time.RFC3339),
+		TxID:     stub.GetTxID(),
+	}
+	key, err := stub.CreateCompositeKey("issue", []string{args[1], record.Time, record.TxID})
+	if err != nil {
+		return "", fmt.Errorf("Create issue key failed! With error: %s", err)
+	}
+	value, err := json.Marshal(record)
+	if err != nil {
+		return "", fmt.Errorf("Encode issuance failed! With error: %s", err)
+	}
+	if err := stub.PutState(key, value); err != nil {
+		return "", fmt.Errorf("Store issuance failed! With error: %s", err)
+	}
+	if err := emitEvent(stub, "issue", newLeg(reserve, acc, amount)); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Issue is success! Reserve: %s; Issued: %s; Remaining balance is: %s", reserve, amount, acc.Balance), nil
+}
+
+// report the money supply of every currency, or of one currency
+// args[0] optionally represents the currency
+// Money created before the reserves, eg. the initial balances of older accounts,
+// and transfers older than the txID index show up as an unbalanced currency.
+func supply(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	lines := make(map[string]*supplyLine)
+	line := func(currency string) (*supplyLine, error) {
+		if l, ok := lines[currency]; ok {
+			return l, nil
+		}
+		scale, err := currencyScale(currency)
+		if err != nil {
+			return nil, err
+		}
+		zero := Money{Scale: scale}
+		lines[currency] = &supplyLine{Currency: currency,
+			Issued: zero, FXIn: zero, FXOut: zero, Reserves: zero, Revenue: zero, Customers: zero}
+		return lines[currency], nil
+	}
+	accumulate := func(sum *Money, amount Money) error {
+		var err error
+		*sum, err = sum.Add(amount)
+		return err
+	}
+
+	// the money issued by the supervisor
+	if err := scanRecords(stub, "issue", func(value []byte) error {
+		var record issuance
+		if err := json.Unmarshal(value, &record); err != nil {
+			return fmt.Errorf("Decode issuance failed! With error: %s", err)
+		}
+		l, err := line(record.Currency)
+		if err != nil {
+			return err
+		}
+		return accumulate(&l.Issued, record.Amount)
+	}); err != nil {
+		return "", err
+	}
+
+	// the money converted from one currency into another
+	if err := scanRecords(stub, "tx", func(value []byte) error {
+		var record transferRecord
+		if err := json.Unmarshal(value, &record); err != nil {
+			return fmt.Errorf("Decode transfer record failed! With error: %s", err)
+		}
+		if record.Currency == record.CreditCurrency {
+			return nil
+		}
+		out, err := line(record.Currency)
+		if err != nil {
+			return err
+		}
+		in, err := line(record.CreditCurrency)
+		if err != nil {
+			return err
+		}
+		if err := accumulate(&out.FXOut, record.Amount); err != nil {
+			return err
+		}
+		return accumulate(&in.FXIn, record.CreditAmount)
+	}); err != nil {
+		return "", err
+	}
+
+	// the money held in the accounts, an empty range visits every account
+	it, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return "", fmt.Errorf("Cannot get accounts by range! With error: %s", err)
+	}
+	defer it.Close()
+	for it.HasNext() {
+		item, err := it.Next()
+		if err != nil {
+			return "", fmt.Errorf("Get next of iterator failed! With error: %s", err)
+		}
+		// a MockStub also returns the composite keys, which a peer leaves out of the range
+		if strings.HasPrefix(item.GetKey(), compositeKeyNamespace) {
+			continue
+		}
+		acc := new(Account)
+		if err := json.Unmarshal(item.GetValue(), acc); err != nil {
+			return "", fmt.Errorf("Corrupted asset: %s with error: %s", item.GetKey(), err)
+		}
+		l, err := line(acc.Currency)
+		if err != nil {
+			return "", err
+		}
+		sum := &l.Customers
+		if strings.HasPrefix(item.GetKey(), systemPrefix+"reserve.") {
+			sum = &l.Reserves
+		} else if strings.HasPrefix(item.GetKey(), systemPrefix+"revenue.") {
+			sum = &l.Revenue
+		}
+		if err := accumulate(sum, acc.Balance); err != nil {
+			return "", err
+		}
+	}
+
+	report := []supplyLine{}
+	for currency, l := range lines {
+		if len(args) > 0 && currency != args[0] {
+			continue
+		}
+		held, err := l.Reserves.Add(l.Revenue)
+		if err == nil {
+			held, err = held.Add(l.Customers)
+		}
+		expected, err2 := l.Issued.Add(l.FXIn)
+		if err2 == nil {
+			expected, err2 = expected.Sub(l.FXOut)
+		}
+		if err != nil || err2 != nil {
+			return "", fmt.Errorf("Amount overflow in the supply of %s!", currency)
+		}
+		l.Balanced = held.Cmp(expected) == 0
+		report = append(report, *l)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Currency < report[j].Currency })
+
+	result, err := json.Marshal(report)
+	if err != nil {
+		return "", fmt.Errorf("Encode supply report failed! With error: %s", err)
+	}
+	return string(result), nil
+}
+
+// scanRecords calls visit with the value of every record of an object type.
+func scanRecords(stub shim.ChaincodeStubInterface, objectType string, visit func([]byte) error) error {
+	it, err := stub.GetStateByPartialCompositeKey(objectType, []string{})
+	if err != nil {
+		return fmt.Errorf("Cannot get by partial composite key! With error: %s", err)
+	}
+	defer it.Close()
+
+	for it.HasNext() {
+		item, err := it.Next()
+		if err != nil {
+			return fmt.Errorf("Get next of iterator failed! With error: %s", err)
+		}
+		if err := visit(item.GetValue()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDepositRequiresBankStaff(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "100")
+
+	// the owner cannot deposit money that the bank never took in
+	l.fail(l.anz, "Only a bank admin or a teller can call add!", "add", "alice", "10")
+	l.fail(l.anz, "Only a bank admin or a teller can create an account with an initial balance!", "create", "bob", "10")
+	if got := l.balance("alice@ANZBank"); got != "100.00" {
+		t.Errorf("balance of alice = %s, want 100.00", got)
+	}
+	if got := l.balance(reserveAccount("ANZBank", "CNY")); got != "999900.00" {
+		t.Errorf("balance of the reserve = %s, want 999900.00", got)
+	}
+
+	// the owner still withdraws, a bank admin or a teller deposits
+	teller := newClient(t, "ANZBankMSP", "anz-teller", map[string]string{roleAttribute: roleTeller})
+	l.ok(l.anz, "reduce", "alice", "30")
+	l.ok(l.anzAdmin, "add", "alice", "5")
+	l.ok(teller, "add", "alice", "5")
+	if got := l.balance("alice@ANZBank"); got != "80.00" {
+		t.Errorf("balance of alice = %s, want 80.00", got)
+	}
+
+	// the reserve only pays out what the supervisor issued
+	l.fail(l.anzAdmin, "is not enough", "add", "alice", "2000000")
+}
+
+func TestSystemAccountsDoNotTransfer(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "100")
+	l.open(l.citi, "bob", "0")
+	l.ok(l.anzAdmin, "setfees", "cross", `[{"from":"0","flat":"1"}]`)
+	l.ok(l.anz, "transfer", "alice", "bob@CitiBank", "10")
+
+	// not even a bank admin moves the reserve or the revenue of its bank by a transfer
+	l.fail(l.anzAdmin, "Cannot transfer from the system account: $reserve.CNY@ANZBank", "transfer", "$reserve.CNY", "bob@CitiBank", "10")
+	l.fail(l.anzAdmin, "Cannot transfer from the system account: $revenue.CNY@ANZBank", "transfer", "$revenue.CNY", "alice@ANZBank", "1")
+	// and nobody pays into the reserve or the revenue of a bank
+	l.fail(l.anz, "Cannot transfer to the system account: $reserve.CNY@CitiBank", "transfer", "alice", "$reserve.CNY@CitiBank", "10")
+	l.fail(l.anz, "Cannot transfer to the system account: $revenue.CNY@ANZBank", "transfer", "alice", "$revenue.CNY@ANZBank", "10")
+
+	if got := l.balance(reserveAccount("ANZBank", "CNY")); got != "999900.00" {
+		t.Errorf("balance of the reserve = %s, want 999900.00", got)
+	}
+	if got := l.balance("alice@ANZBank"); got != "89.00" {
+		t.Errorf("balance of alice = %s, want 89.00", got)
+	}
+}
+
+func TestSupplyBalancesIssuedAgainstHeld(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "1000")
+	l.open(l.citi, "bob", "0", "USD")
+	l.ok(l.supervisor, "setrate", "CNY", "USD", "0.14")
+	l.ok(l.anzAdmin, "setfees", "cross", `[{"from":"0","flat":"1"}]`)
+	l.ok(l.anz, "transfer", "alice", "bob@CitiBank", "100")
+
+	supply := func(currency string) supplyLine {
+		t.Helper()
+		var report []supplyLine
+		if err := json.Unmarshal([]byte(l.ok(l.supervisor, "supply", currency)), &report); err != nil {
+			t.Fatalf("Decode supply report failed! With error: %s", err)
+		}
+		if len(report) != 1 {
+			t.Fatalf("supply %s = %+v, want one line", currency, report)
+		}
+		return report[0]
+	}
+	// the 100 CNY converted into 14 USD leave the CNY supply and join the USD one
+	cny := supply("CNY")
+	if !cny.Balanced || cny.Issued.String() != "2000000.00" || cny.FXOut.String() != "100.00" ||
+		cny.Reserves.String() != "1999000.00" || cny.Revenue.String() != "1.00" || cny.Customers.String() != "899.00" {
+		t.Errorf("supply of CNY = %+v", cny)
+	}
+	usd := supply("USD")
+	if !usd.Balanced || usd.FXIn.String() != "14.00" || usd.Customers.String() != "14.00" {
+		t.Errorf("supply of USD = %+v", usd)
+	}
+
+	// money that appears in an account without being issued unbalances the currency
+	l.stub.State["alice@ANZBank"] = []byte(strings.Replace(string(l.stub.State["alice@ANZBank"]), `"899.00"`, `"999.00"`, 1))
+	if cny := supply("CNY"); cny.Balanced || cny.Customers.String() != "999.00" {
+		t.Errorf("supply of CNY after the tampering = %+v, want it unbalanced", cny)
+	}
+	l.fail(l.anz, "You do not have authority to get access to this function!", "supply")
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRollbackKeepsHistory(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "100")
+	l.open(l.citi, "bob", "0")
+	l.ok(l.anz, "transfer", "alice", "bob@CitiBank", "30")
+	paid := l.lastTx()
+
+	l.fail(l.anz, "You do not have authority to get access to this function!", "rollback", "alice@ANZBank", "bob@CitiBank", paid)
+	l.fail(l.supervisor, "Database do not have such \"out\" records!", "rollback", "alice@ANZBank", "bob@CitiBank", "tx999")
+	l.ok(l.supervisor, "rollback", "alice@ANZBank", "bob@CitiBank", paid)
+	reversal := l.lastTx()
+	if got := l.balance("alice@ANZBank"); got != "100.00" {
+		t.Errorf("balance of alice = %s, want 100.00", got)
+	}
+	if got := l.balance("bob@CitiBank"); got != "0.00" {
+		t.Errorf("balance of bob = %s, want 0.00", got)
+	}
+
+	// the original records are kept and marked, the compensating ones link back to them
+	if got := l.ok(l.anz, "query", "out", "alice"); !strings.Contains(got, "alice@ANZBank -> bob@CitiBank\t"+paid) ||
+		!strings.Contains(got, "30.00 CNY [reversed by "+reversal+"]") {
+		t.Errorf("query out of alice = %s, want the transfer reversed by %s", got, reversal)
+	}
+	if got := l.ok(l.citi, "query", "in", "bob"); !strings.Contains(got, "30.00 CNY [reversed by "+reversal+"]") {
+		t.Errorf("query in of bob = %s, want the transfer reversed by %s", got, reversal)
+	}
+	if got := l.ok(l.citi, "query", "out", "bob"); !strings.Contains(got, "bob@CitiBank -> alice@ANZBank\t"+reversal) ||
+		!strings.Contains(got, "30.00 CNY [reversal of "+paid+"]") {
+		t.Errorf("query out of bob = %s, want the reversal of %s", got, paid)
+	}
+	if got := l.ok(l.anz, "query", "in", "alice"); !strings.Contains(got, "30.00 CNY [reversal of "+paid+"]") {
+		t.Errorf("query in of alice = %s, want the reversal of %s", got, paid)
+	}
+	if records := l.lookup(l.supervisor, paid); len(records) != 1 || records[0].Status != recordReversed || records[0].ReversedBy != reversal {
+		t.Errorf("tx %s = %+v, want it reversed by %s", paid, records, reversal)
+	}
+
+	// a transaction is reversed once, and a reversal is not reversed in turn
+	l.fail(l.supervisor, "Transaction "+paid+" has already been reversed by transaction "+reversal+"!",
+		"rollback", "alice@ANZBank", "bob@CitiBank", paid)
+	l.fail(l.supervisor, "Transaction "+reversal+" is a reversal itself and cannot be rolled back!",
+		"rollback", "bob@CitiBank", "alice@ANZBank", reversal)
+}
+
+func TestRollbackKeepsHolds(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "100")
+	l.open(l.citi, "bob", "0")
+	l.ok(l.anz, "transfer", "alice", "bob@CitiBank", "30")
+	transfer := l.lastTx()
+
+	// bob holds what he received for carol, it is not his to give back
+	l.open(l.citi, "carol", "0")
+	l.ok(l.citi, "hold", "bob", "carol@CitiBank", "20", "72h")
+	hold := l.lastTx()
+	l.fail(l.supervisor, "The balance in bob@CitiBank's account is not enough to reduce!",
+		"rollback", "alice@ANZBank", "bob@CitiBank", transfer)
+
+	// nor does an upheld dispute take it back
+	l.ok(l.anz, "dispute", transfer, "bob@CitiBank", "not authorised")
+	dispute := l.lastTx()
+	l.ok(l.supervisor, "reviewdispute", dispute)
+	l.fail(l.supervisor, "The balance in bob@CitiBank's account is not enough to reduce!",
+		"decidedispute", dispute, "uphold", "refund")
+	if got := l.balance("bob@CitiBank"); got != "30.00" {
+		t.Errorf("balance of bob = %s, want 30.00", got)
+	}
+
+	// once the hold is released the transfer rolls back, only once
+	l.ok(l.citi, "release", "bob@CitiBank", hold)
+	l.ok(l.supervisor, "rollback", "alice@ANZBank", "bob@CitiBank", transfer)
+	l.fail(l.supervisor, "has already been reversed", "rollback", "alice@ANZBank", "bob@CitiBank", transfer)
+	if got := l.balance("alice@ANZBank"); got != "100.00" {
+		t.Errorf("balance of alice = %s, want 100.00", got)
+	}
+	if got := l.balance("bob@CitiBank"); got != "0.00" {
+		t.Errorf("balance of bob = %s, want 0.00", got)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// positionDelta is the JSON document stored under the composite key
+// ["position", bankA, bankB, currency, txID, credit account], the change one cross-bank
+// transfer made to what bankA owes bankB, where bankA sorts before bankB.
+// Amount is positive when bankA owes more, it is the amount the credit bank paid to its
+// account, in that currency. The changes are added up when read, so that concurrent
+// transfers between the same banks never write the same key.
+type positionDelta struct {
+	Amount Money  `json:"amount"`
+	Debit  string `json:"debit"`
+	Credit string `json:"credit"`
+	Time   string `json:"time"`
+}
+
+// bankPosition is the running position of a pair of banks in a currency.
+// Net is what BankA owes BankB, negative when BankB owes BankA.
+type bankPosition struct {
+	BankA     string `json:"bankA"`
+	BankB     string `json:"bankB"`
+	Currency  string `json:"currency"`
+	Net       Money  `json:"net"`
+	Transfers int    `json:"transfers"`
+}
+
+// obligation is what one bank has to pay another at the end of a settlement cycle.
+type obligation struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Currency string `json:"currency"`
+	Amount   Money  `json:"amount"`
+}
+
+// settlementCycle is the JSON document stored under the composite key ["settlement", time, txID],
+// the net obligations of a closed settlement cycle.
+type settlementCycle struct {
+	ID          string       `json:"id"`
+	Time        string       `json:"time"`
+	SettledBy   string       `json:"settledBy"`
+	Transfers   int          `json:"transfers"`
+	Obligations []obligation `json:"obligations"`
+}
+
+// involves reports whether a bank takes part in an obligation, an empty bank takes part in all.
+func (o obligation) involves(bank string) bool {
+	return bank == "" || o.From == bank || o.To == bank
+}
+
+// recordPosition adds a transfer to the position of the debit and the credit bank,
+// it does nothing when both accounts are at the same bank.
+// amount is what the debit bank owes the credit bank because of the transfer, in currency;
+// a rollback records the opposite amount of the transfer it reverses.
+func recordPosition(stub shim.ChaincodeStubInterface, debitAccount, creditAccount string, amount Money, currency string) error {
+	bankA, bankB := bankOf(debitAccount), bankOf(creditAccount)
+	if bankA == bankB {
+		return nil
+	}
+	if bankA > bankB {
+		bankA, bankB = bankB, bankA
+		amount = amount.Neg()
+	}
+
+	tm, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	key, err := stub.CreateCompositeKey("position", []string{bankA, bankB, currency, stub.GetTxID(), creditAccount})
+	if err != nil {
+		return fmt.Errorf("Create position key failed! With error: %s", err)
+	}
+	value, err := json.Marshal(positionDelta{
+		Amount: amount,
+		Debit:  debitAccount,
+		Credit: creditAccount,
+		Time:   tm.Format(time.RFC3339),
+	})
+	if err != nil {
+		return fmt.Errorf("Encode position failed! With error: %s", err)
+	}
+	if err := stub.PutState(key, value); err != nil {
+		return fmt.Errorf("Store position failed! With error: %s", err)
+	}
+	return nil
+}
+
+// readPositions adds up the open position changes of every pair of banks and currency.
+// It also returns the keys of the changes, which a settlement removes.
+func readPositions(stub shim.ChaincodeStubInterface) ([]bankPosition, []string, error) {
+	it, err := stub.GetStateByPartialCompositeKey("position", []string{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("Cannot get by partial composite key! With error: %s", err)
+	}
+	defer it.Close()
+
+	// the keys are sorted by the pair of banks and the currency, so each position is a run of keys
+	list := []bankPosition{}
+	var keys []string
+	for it.HasNext() {
+		item, err := it.Next()
+		if err != nil {
+			return nil, nil, fmt.Errorf("Get next of iterator failed! With error: %s", err)
+		}
+		_, attrArray, err := stub.SplitCompositeKey(item.GetKey())
+		if err != nil {
+			return nil, nil, fmt.Errorf("Split composite key failed! With error: %s", err)
+		}
+		var delta positionDelta
+		if err := json.Unmarshal(item.GetValue(), &delta); err != nil {
+			return nil, nil, fmt.Errorf("Decode position failed! With error: %s", err)
+		}
+
+		last := len(list) - 1
+		if last < 0 || list[last].BankA != attrArray[0] || list[last].BankB != attrArray[1] || list[last].Currency != attrArray[2] {
+			list = append(list, bankPosition{BankA: attrArray[0], BankB: attrArray[1], Currency: attrArray[2],
+				Net: Money{Scale: delta.Amount.Scale}})
+			last++
+		}
+		if list[last].Net, err = list[last].Net.Add(delta.Amount); err != nil {
+			return nil, nil, err
+		}
+		list[last].Transfers++
+		keys = append(keys, item.GetKey())
+	}
+	return list, keys, nil
+}
+
+// list the running positions between the banks
+// args[0] optionally represents a bank, only its positions are listed
+func positions(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	list, _, err := readPositions(stub)
+	if err != nil {
+		return "", err
+	}
+
+	filtered := []bankPosition{}
+	for _, p := range list {
+		if len(args) == 0 || p.BankA == args[0] || p.BankB == args[0] {
+			filtered = append(filtered, p)
+		}
+	}
+	result, err := json.Marshal(filtered)
+	if err != nil {
+		return "", fmt.Errorf("Encode positions failed! With error: %s", err)
+	}
+	return string(result), nil
+}
+
+// the supervisor closes a settlement cycle: the running positions become the net obligations
+// between the banks, which are recorded, and the positions start again from zero
+// args[0] represents the identity of the supervisor
+func settle(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	list, keys, err := readPositions(stub)
+	if err != nil {
+		return "", err
+	}
+	tm, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+
+	cycle := settlementCycle{
+		ID:          stub.GetTxID(),
+		Time:        tm.Format(time.RFC3339),
+		SettledBy:   args[0],
+		Transfers:   len(keys),
+		Obligations: []obligation{},
+	}
+	for _, p := range list {
+		switch p.Net.Sign() {
+		case 1:
+			cycle.Obligations = append(cycle.Obligations, obligation{From: p.BankA, To: p.BankB, Currency: p.Currency, Amount: p.Net})
+		case -1:
+			cycle.Obligations = append(cycle.Obligations, obligation{From: p.BankB, To: p.BankA, Currency: p.Currency, Amount: p.Net.Neg()})
+		}
+	}
+
+	key, err := stub.CreateCompositeKey("settlement", []string{cycle.Time, cycle.ID})
+	if err != nil {
+		return "", fmt.Errorf("Create settlement key failed! With error: %s", err)
+	}
+	value, err := json.Marshal(cycle)
+	if err != nil {
+		return "", fmt.Errorf("Encode settlement failed! With error: %s", err)
+	}
+	if err := stub.PutState(key, value); err != nil {
+		return "", fmt.Errorf("Store settlement failed! With error: %s", err)
+	}
+	for _, key := range keys {
+		if err := stub.DelState(key); err != nil {
+			return "", fmt.Errorf("Reset position failed! With error: %s", err)
+		}
+	}
+
+	return fmt.Sprintf("Settle is success! Cycle: %s; Transfers: %d; Obligations: %d",
+		cycle.ID, cycle.Transfers, len(cycle.Obligations)), nil
+}
+
+// list the closed settlement cycles, from the oldest to the newest
+// args[0] optionally represents a bank, only its obligations are listed
+func settlements(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	bank := ""
+	if len(args) > 0 {
+		bank = args[0]
+	}
+
+	list := []settlementCycle{}
+	if err := scanRecords(stub, "settlement", func(value []byte) error {
+		var cycle settlementCycle
+		if err := json.Unmarshal(value, &cycle); err != nil {
+			return fmt.Errorf("Decode settlement failed! With error: %s", err)
+		}
+		obligations := []obligation{}
+		for _, o := range cycle.Obligations {
+			if o.involves(bank) {
+				obligations = append(obligations, o)
+			}
+		}
+		cycle.Obligations = obligations
+		list = append(list, cycle)
+		return nil
+	}); err != nil {
+		return "", err
+	}
+
+	result, err := json.Marshal(list)
+	if err != nil {
+		return "", fmt.Errorf("Encode settlements failed! With error: %s", err)
+	}
+	return string(result), nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+)
+
+func TestNetSettlementBetweenBanks(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "100")
+	l.open(l.anz, "carol", "100")
+	l.open(l.anz, "dave", "100", "USD")
+	l.open(l.citi, "bob", "100")
+	l.open(l.citi, "erin", "0", "USD")
+
+	l.ok(l.anz, "transfer", "alice", "bob@CitiBank", "100")
+	l.ok(l.citi, "transfer", "bob", "alice@ANZBank", "30")
+	back := l.lastTx()
+	l.ok(l.anz, "transfer", "carol", "alice@ANZBank", "5")
+	l.ok(l.anz, "transfer", "dave", "erin@CitiBank", "20")
+	// a rollback takes its transfer out of the position
+	l.ok(l.supervisor, "rollback", "bob@CitiBank", "alice@ANZBank", back)
+
+	var list []bankPosition
+	if err := json.Unmarshal([]byte(l.ok(l.citi, "positions")), &list); err != nil {
+		t.Fatalf("Decode positions failed! With error: %s", err)
+	}
+	if got := fmt.Sprint(list); got != "[{ANZBank CitiBank CNY 100.00 3} {ANZBank CitiBank USD 20.00 1}]" {
+		t.Errorf("positions = %s, want ANZBank to owe 100.00 CNY and 20.00 USD", got)
+	}
+
+	l.fail(l.anzAdmin, "You do not have authority to get access to this function!", "settle")
+	l.ok(l.supervisor, "settle")
+	if got := l.ok(l.supervisor, "positions"); got != "[]" {
+		t.Errorf("positions after the settlement = %s, want none", got)
+	}
+	var cycles []settlementCycle
+	if err := json.Unmarshal([]byte(l.ok(l.anz, "settlements")), &cycles); err != nil {
+		t.Fatalf("Decode settlements failed! With error: %s", err)
+	}
+	if len(cycles) != 1 || cycles[0].Transfers != 4 || fmt.Sprint(cycles[0].Obligations) != "[{ANZBank CitiBank CNY 100.00} {ANZBank CitiBank USD 20.00}]" {
+		t.Errorf("settlements = %+v, want ANZBank to pay 100.00 CNY and 20.00 USD to CitiBank", cycles)
+	}
+}
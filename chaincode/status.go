@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/lib/cid"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// statusChange is the JSON document stored under the composite key
+// ["status", account, time, txID], one for every change of an account status.
+// Actor is the identity (cid GetID) of the one who made the change.
+type statusChange struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Reason string `json:"reason"`
+	Actor  string `json:"actor"`
+	MSPID  string `json:"mspid"`
+	Time   string `json:"time"`
+	TxID   string `json:"txID"`
+}
+
+// checkUsable rejects debiting an account that is not active, eg. a frozen, dormant or pending one.
+func checkUsable(account string, acc *Account) error {
+	if acc.Status != statusActive {
+		return fmt.Errorf("Account %s is %s!", account, acc.Status)
+	}
+	return nil
+}
+
+// checkCreditable rejects paying into a frozen account, every other open account accepts money.
+func checkCreditable(account string, acc *Account) error {
+	if acc.Status == statusFrozen {
+		return fmt.Errorf("Account %s is frozen!", account)
+	}
+	return nil
+}
+
+// checkUnlinked rejects closing an account that a live record would still move money in or out of:
+// a pending payment, an active standing order, a pending remittance or an open dispute, on either side.
+func checkUnlinked(stub shim.ChaincodeStubInterface, account string) error {
+	tm, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	now := tm.Format(time.RFC3339)
+
+	if err := scanRecords(stub, "payment", func(value []byte) error {
+		var p payment
+		if err := json.Unmarshal(value, &p); err != nil {
+			return fmt.Errorf("Decode payment failed! With error: %s", err)
+		}
+		if p.Status == paymentPending && !p.expired(now) && (p.Debit == account || p.Credit == account) {
+			return fmt.Errorf("Account %s has the pending payment %s, reject it first!", account, p.ID)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	orders, err := listOrders(stub, "")
+	if err != nil {
+		return err
+	}
+	for _, o := range orders {
+		if o.Status == orderActive && (o.Account == account || o.Credit == account) {
+			return fmt.Errorf("Account %s has the active standing order %s, cancel it first!", account, o.ID)
+		}
+	}
+
+	remittances, err := listRemittances(stub)
+	if err != nil {
+		return err
+	}
+	for _, r := range remittances {
+		if r.Status == remitPending && now < r.Expiry && (r.Debit == account || r.Credit == account) {
+			return fmt.Errorf("Account %s has the pending remittance %s, it cannot be closed before it is answered!", account, r.ID)
+		}
+	}
+
+	disputes, err := listDisputes(stub)
+	if err != nil {
+		return err
+	}
+	for _, d := range disputes {
+		if d.live() && (d.Debit == account || d.Credit == account) {
+			return fmt.Errorf("Account %s has the open dispute %s, it cannot be closed before it is decided!", account, d.ID)
+		}
+	}
+	return nil
+}
+
+// touch stamps a movement of money in or out of an account, the caller writes the account.
+// A pending account becomes active with the first money that comes in.
+func touch(stub shim.ChaincodeStubInterface, account string, acc *Account, incoming bool) error {
+	tm, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	acc.LastActivity = tm.Format(time.RFC3339)
+	if !incoming || acc.Status != statusPending {
+		return nil
+	}
+
+	actor, err := cid.GetID(stub)
+	if err != nil {
+		return fmt.Errorf("Get client ID failed! With error: %s", err)
+	}
+	mspid, err := cid.GetMSPID(stub)
+	if err != nil {
+		return fmt.Errorf("Get client MSPID failed! With error: %s", err)
+	}
+	return recordStatus(stub, account, acc, statusActive, "first incoming money", actor, mspid)
+}
+
+// setStatus changes the status of an account, records the change and writes the account.
+func setStatus(stub shim.ChaincodeStubInterface, account string, acc *Account, to, reason, actor, mspid string) error {
+	if err := recordStatus(stub, account, acc, to, reason, actor, mspid); err != nil {
+		return err
+	}
+	return putAccount(stub, account, acc)
+}
+
+// recordStatus changes the status of an account and records the change, the caller writes the account.
+func recordStatus(stub shim.ChaincodeStubInterface, account string, acc *Account, to, reason, actor, mspid string) error {
+	tm, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	change := statusChange{
+		From:   acc.Status,
+		To:     to,
+		Reason: reason,
+		Actor:  actor,
+		MSPID:  mspid,
+		Time:   tm.Format(time.RFC3339),
+		TxID:   stub.GetTxID(),
+	}
+
+	acc.Status = to
+	key, err := stub.CreateCompositeKey("status", []string{account, change.Time, change.TxID})
+	if err != nil {
+		return fmt.Errorf("Create status key failed! With error: %s", err)
+	}
+	value, err := json.Marshal(change)
+	if err != nil {
+		return fmt.Errorf("Encode status change failed! With error: %s", err)
+	}
+	if err := stub.PutState(key, value); err != nil {
+		return fmt.Errorf("Store status change failed! With error: %s", err)
+	}
+	return nil
+}
+
+// the supervisor freezes an account
+// args[0] represents the full account
+// args[1] represents the reason
+// args[2] represents the identity of the supervisor
+// args[3] represents the MSPID of the supervisor
+func freeze(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	acc, err := getAccount(stub, args[0])
+	if err != nil {
+		return "", err
+	}
+	if acc.Status == statusFrozen {
+		return "", fmt.Errorf("Account %s is already frozen!", args[0])
+	}
+
+	acc.FrozenFrom = acc.Status
+	if err := setStatus(stub, args[0], acc, statusFrozen, args[1], args[2], args[3]); err != nil {
+		return "", fmt.Errorf("Freeze account failed! With error: %s", err)
+	}
+	return fmt.Sprintf("Freeze is success! Account: %s; Reason: %s", args[0], args[1]), nil
+}
+
+// the supervisor unfreezes an account, which gets back the status it had before the freeze
+// args[0] represents the full account
+// args[1] represents the reason
+// args[2] represents the identity of the supervisor
+// args[3] represents the MSPID of the supervisor
+func unfreeze(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	acc, err := getAccount(stub, args[0])
+	if err != nil {
+		return "", err
+	}
+	if acc.Status != statusFrozen {
+		return "", fmt.Errorf("Account %s is not frozen!", args[0])
+	}
+
+	restored := acc.FrozenFrom
+	acc.FrozenFrom = ""
+	if err := setStatus(stub, args[0], acc, restored, args[1], args[2], args[3]); err != nil {
+		return "", fmt.Errorf("Unfreeze account failed! With error: %s", err)
+	}
+	return fmt.Sprintf("Unfreeze is success! Account: %s; Status: %s; Reason: %s", args[0], restored, args[1]), nil
+}
+
+// a bank admin activates a pending or dormant account
+// args[0] represents the full account
+// args[1] represents the reason
+// args[2] represents the identity of the bank admin
+// args[3] represents the MSPID of the bank admin
+func activate(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	acc, err := getAccount(stub, args[0])
+	if err != nil {
+		return "", err
+	}
+	if acc.Status != statusPending && acc.Status != statusDormant {
+		return "", fmt.Errorf("Account %s is %s, only a pending or dormant account can be activated!", args[0], acc.Status)
+	}
+
+	if err := setStatus(stub, args[0], acc, statusActive, args[1], args[2], args[3]); err != nil {
+		return "", fmt.Errorf("Activate account failed! With error: %s", err)
+	}
+	return fmt.Sprintf("Activate is success! Account: %s; Reason: %s", args[0], args[1]), nil
+}
+
+// a bank admin makes dormant the active accounts of the bank without movements for a while
+// args[0] represents the bank
+// args[1] represents how long an account stays idle before it is dormant, eg. "8760h"
+// args[2] represents the identity of the bank admin
+// args[3] represents the MSPID of the bank admin
+func markDormant(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	idle, err := time.ParseDuration(args[1])
+	if err != nil || idle <= 0 {
+		return "", fmt.Errorf("Invalid idle time: %s, expecting a duration such as 8760h", args[1])
+	}
+	tm, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+	since := tm.Add(-idle).Format(time.RFC3339)
+
+	// an empty range visits every simple key, that is, every account
+	it, err := stub.GetStateByRange("", "")
+	if err != nil {
+		return "", fmt.Errorf("Cannot get accounts by range! With error: %s", err)
+	}
+	defer it.Close()
+
+	buffered := newTxStub(stub)
+	marked := 0
+	for it.HasNext() {
+		item, err := it.Next()
+		if err != nil {
+			return "", fmt.Errorf("Get next of iterator failed! With error: %s", err)
+		}
+		account := item.GetKey()
+		if bankOf(account) != args[0] || isSystemAccount(account) {
+			continue
+		}
+		acc := new(Account)
+		if err := json.Unmarshal(item.GetValue(), acc); err != nil {
+			return "", fmt.Errorf("Corrupted asset: %s with error: %s", account, err)
+		}
+		acc.upgrade()
+		if acc.Status != statusActive || acc.LastActivity >= since {
+			continue
+		}
+		reason := "no movement since " + acc.LastActivity
+		if err := setStatus(buffered, account, acc, statusDormant, reason, args[2], args[3]); err != nil {
+			return "", fmt.Errorf("Mark account %s dormant failed! With error: %s", account, err)
+		}
+		marked++
+	}
+
+	if err := buffered.flush(); err != nil {
+		return "", fmt.Errorf("Store dormant accounts failed! With error: %s", err)
+	}
+	return fmt.Sprintf("Dormant is success! Bank: %s; Idle since: %s; Accounts: %d", args[0], since, marked), nil
+}
+
+// query the status changes of an account, from the oldest to the newest.
+// args[0] represents the full account
+func statusLog(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	it, err := stub.GetStateByPartialCompositeKey("status", []string{args[0]})
+	if err != nil {
+		return "", fmt.Errorf("Cannot get by partial composite key! With error: %s", err)
+	}
+	defer it.Close()
+
+	changes := []statusChange{}
+	for it.HasNext() {
+		item, err := it.Next()
+		if err != nil {
+			return "", fmt.Errorf("Get next of iterator failed! With error: %s", err)
+		}
+		var change statusChange
+		if err := json.Unmarshal(item.GetValue(), &change); err != nil {
+			return "", fmt.Errorf("Decode status change failed! With error: %s", err)
+		}
+		changes = append(changes, change)
+	}
+
+	result, err := json.Marshal(changes)
+	if err != nil {
+		return "", fmt.Errorf("Encode status changes failed! With error: %s", err)
+	}
+	return string(result), nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestFrozenAccountMovesNoMoney(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "100")
+	l.open(l.citi, "bob", "100")
+
+	l.fail(l.citiAdmin, "You do not have authority to get access to this function!", "freeze", "bob@CitiBank", "by the bank")
+	l.ok(l.supervisor, "freeze", "bob@CitiBank", "court order")
+	l.fail(l.anz, "Account bob@CitiBank is frozen!", "transfer", "alice", "bob@CitiBank", "10")
+	l.fail(l.citi, "Account bob@CitiBank is frozen!", "transfer", "bob", "alice@ANZBank", "10")
+	l.fail(l.citi, "Account bob@CitiBank is frozen!", "reduce", "bob", "10")
+	l.ok(l.supervisor, "unfreeze", "bob@CitiBank", "order lifted")
+	l.ok(l.anz, "transfer", "alice", "bob@CitiBank", "10")
+
+	var changes []statusChange
+	if err := json.Unmarshal([]byte(l.ok(l.supervisor, "statuslog", "bob@CitiBank")), &changes); err != nil {
+		t.Fatalf("Decode status log failed! With error: %s", err)
+	}
+	// created pending, activated by the deposit, then frozen and unfrozen
+	last := changes[len(changes)-2:]
+	if last[0].To != statusFrozen || last[0].Reason != "court order" || last[0].MSPID != "SuperviMSP" ||
+		last[1].From != statusFrozen || last[1].To != statusActive || last[1].Reason != "order lifted" {
+		t.Errorf("status log = %+v, want the freeze and the unfreeze last", changes)
+	}
+	if got := l.balance("bob@CitiBank"); got != "110.00" {
+		t.Errorf("balance of bob = %s, want 110.00", got)
+	}
+}
+
+func TestUnfreezeRestoresStatus(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "100")
+	l.open(l.anz, "pending", "0")
+	l.open(l.anz, "dormant", "10")
+	if _, err := l.call(time.Now().Add(48*time.Hour), markDormant, "ANZBank", "24h", "anz-admin", "ANZBankMSP"); err != nil {
+		t.Fatalf("markDormant failed: %s", err)
+	}
+	l.ok(l.anzAdmin, "activate", "alice", "still in use")
+
+	for account, want := range map[string]string{
+		"alice@ANZBank":   statusActive,
+		"pending@ANZBank": statusPending,
+		"dormant@ANZBank": statusDormant,
+	} {
+		l.ok(l.supervisor, "freeze", account, "investigation")
+		l.fail(l.supervisor, "is already frozen", "freeze", account, "again")
+		l.fail(l.anz, "is frozen", "reduce", account[:len(account)-len("@ANZBank")], "1")
+		l.ok(l.supervisor, "unfreeze", account, "cleared")
+		if got := l.account(account).Status; got != want {
+			t.Errorf("status of %s after unfreeze = %s, want %s", account, got, want)
+		}
+	}
+	l.fail(l.supervisor, "is not frozen", "unfreeze", "alice@ANZBank", "again")
+}
+
+func TestCloseRefusedWhileLinked(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "0")
+	l.open(l.anz, "carol", "1000")
+	l.open(l.citi, "bob", "1000")
+	l.ok(l.anz, "transfer", "carol", "alice@ANZBank", "10")
+	paid := l.lastTx()
+	l.ok(l.anz, "transfer", "alice", "carol@ANZBank", "10")
+
+	l.ok(l.anzAdmin, "setapproval", "CNY", "100", "1", "72h")
+	l.ok(l.anz, "transfer", "carol", "alice@ANZBank", "200")
+	payment := l.lastTx()
+	l.fail(l.anz, "Account alice@ANZBank has the pending payment "+payment, "delete", "alice")
+	l.ok(l.anz, "reject", "carol", payment, "wrong account")
+
+	l.ok(l.anz, "order", "carol", "alice@ANZBank", "5", "monthly", "2099-01-01")
+	order := l.lastTx()
+	l.fail(l.anz, "Account alice@ANZBank has the active standing order "+order, "delete", "alice")
+	l.ok(l.anz, "cancelorder", "carol", order)
+
+	l.ok(l.citi, "remit", "bob", "alice@ANZBank", "5", "72h")
+	remittance := l.lastTx()
+	l.fail(l.anz, "Account alice@ANZBank has the pending remittance "+remittance, "delete", "alice")
+	l.ok(l.anz, "rejectremit", remittance, "closing")
+
+	l.ok(l.anz, "dispute", paid, "alice@ANZBank", "not mine")
+	dispute := l.lastTx()
+	l.fail(l.anz, "Account alice@ANZBank has the open dispute "+dispute, "delete", "alice")
+	l.ok(l.supervisor, "reviewdispute", dispute)
+	l.ok(l.supervisor, "decidedispute", dispute, "reject", "paid as ordered")
+
+	l.ok(l.anz, "delete", "alice")
+	l.fail(l.anz, "Account alice@ANZBank is closed!", "get", "alice")
+	l.fail(l.anz, "its name cannot be reused", "create", "alice", "0")
+}
+
+func TestCloseRefusedWhileHeld(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "10")
+	l.open(l.citi, "bob", "0")
+	l.ok(l.anz, "transfer", "alice", "bob@CitiBank", "10")
+	// the overdraft lets the empty account hold, and is no reason to keep it open
+	l.ok(l.anzAdmin, "setcredit", "alice", "100")
+	l.ok(l.anz, "hold", "alice", "bob@CitiBank", "30", "72h")
+	hold := l.lastTx()
+	l.fail(l.anz, "Account alice@ANZBank has 30.00 held by live holds, it cannot be closed!", "delete", "alice")
+
+	l.ok(l.citi, "release", "alice@ANZBank", hold)
+	l.ok(l.anz, "delete", "alice")
+}
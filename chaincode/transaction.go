@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// recordPosted is the status of a transfer that has not been rolled back
+const recordPosted = "posted"
+
+// transferRecord is the JSON document stored under the composite key ["tx", txID, credit],
+// which indexes every transfer by the transaction that executed it.
+// A transaction may execute several transfers, eg. a batch transfer, one to each credit account.
+// Amount is debited in Currency, CreditAmount is credited in CreditCurrency.
+// Fee is debited in Currency on top of Amount.
+type transferRecord struct {
+	TxID           string `json:"txID"`
+	Debit          string `json:"debit"`
+	Credit         string `json:"credit"`
+	Amount         Money  `json:"amount"`
+	Currency       string `json:"currency"`
+	CreditAmount   Money  `json:"creditAmount"`
+	CreditCurrency string `json:"creditCurrency"`
+	Rate           string `json:"rate,omitempty"`
+	Fee            *Money `json:"fee,omitempty"`
+	Time           string `json:"time"`
+	Status         string `json:"status"`
+	ReversedBy     string `json:"reversedBy,omitempty"`
+	ReversalOf     string `json:"reversalOf,omitempty"`
+}
+
+// indexTransfer writes the txID index of a transfer executed by the current transaction.
+// out and in are the history records of the debit and the credit side.
+func indexTransfer(stub shim.ChaincodeStubInterface, debit, credit string, out, in historyRecord) error {
+	tm, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+
+	return putTransfer(stub, &transferRecord{
+		TxID:           stub.GetTxID(),
+		Debit:          debit,
+		Credit:         credit,
+		Amount:         out.Amount,
+		Currency:       out.Currency,
+		CreditAmount:   in.Amount,
+		CreditCurrency: in.Currency,
+		Rate:           out.Rate,
+		Fee:            out.Fee,
+		Time:           tm.Format(time.RFC3339),
+		Status:         recordPosted,
+		ReversalOf:     out.ReversalOf,
+	})
+}
+
+// getTransfer reads the transfer of a transaction to a credit account.
+// It returns nil if the transfer is not indexed, eg. a transfer made
+// before the index was introduced.
+func getTransfer(stub shim.ChaincodeStubInterface, txID, credit string) (*transferRecord, error) {
+	key, err := stub.CreateCompositeKey("tx", []string{txID, credit})
+	if err != nil {
+		return nil, fmt.Errorf("Create transaction key failed! With error: %s", err)
+	}
+	value, err := stub.GetState(key)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get transaction: %s with error: %s", txID, err)
+	}
+	if value == nil {
+		return nil, nil
+	}
+
+	record := new(transferRecord)
+	if err := json.Unmarshal(value, record); err != nil {
+		return nil, fmt.Errorf("Corrupted transaction: %s with error: %s", txID, err)
+	}
+	return record, nil
+}
+
+// putTransfer writes a transfer record under its txID and credit account.
+func putTransfer(stub shim.ChaincodeStubInterface, record *transferRecord) error {
+	key, err := stub.CreateCompositeKey("tx", []string{record.TxID, record.Credit})
+	if err != nil {
+		return fmt.Errorf("Create transaction key failed! With error: %s", err)
+	}
+	value, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("Encode transaction failed! With error: %s", err)
+	}
+	if err := stub.PutState(key, value); err != nil {
+		return fmt.Errorf("Store transaction failed! With error: %s", err)
+	}
+	return nil
+}
+
+// look up the transfers of a transaction.
+// args[0] represents the transaction id
+// args[1] optionally represents the bank of the caller, who only sees the transfers
+// its accounts take part in; the supervisor calls without it and sees every transfer.
+func tx(stub shim.ChaincodeStubInterface, args []string) (string, error) {
+	it, err := stub.GetStateByPartialCompositeKey("tx", []string{args[0]})
+	if err != nil {
+		return "", fmt.Errorf("Cannot get by partial composite key! With error: %s", err)
+	}
+	defer it.Close()
+
+	records := []transferRecord{}
+	for it.HasNext() {
+		item, err := it.Next()
+		if err != nil {
+			return "", fmt.Errorf("Get next of iterator failed! With error: %s", err)
+		}
+		var record transferRecord
+		if err := json.Unmarshal(item.GetValue(), &record); err != nil {
+			return "", fmt.Errorf("Corrupted transaction: %s with error: %s", args[0], err)
+		}
+		if len(args) > 1 && bankOf(record.Debit) != args[1] && bankOf(record.Credit) != args[1] {
+			continue
+		}
+		records = append(records, record)
+	}
+	// do not reveal that a transaction of other banks exists
+	if len(records) == 0 {
+		return "", fmt.Errorf("Transaction not found: %s", args[0])
+	}
+
+	result, err := json.Marshal(records)
+	if err != nil {
+		return "", fmt.Errorf("Encode transaction failed! With error: %s", err)
+	}
+	return string(result), nil
+}
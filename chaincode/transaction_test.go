@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// lookup finds the transfers of a transaction as a client sees them.
+func (l *testLedger) lookup(client testClient, txID string) []transferRecord {
+	l.t.Helper()
+	var records []transferRecord
+	if err := json.Unmarshal([]byte(l.ok(client, "tx", txID)), &records); err != nil {
+		l.t.Fatalf("Decode transaction failed! With error: %s", err)
+	}
+	return records
+}
+
+func TestTxLookup(t *testing.T) {
+	l := newLedger(t)
+	l.open(l.anz, "alice", "100")
+	l.open(l.anz, "carol", "0")
+	l.open(l.citi, "bob", "0")
+
+	l.ok(l.anz, "transfer", "alice", "bob@CitiBank", "10")
+	cross := l.lastTx()
+	for _, client := range []testClient{l.anz, l.citi, l.supervisor} {
+		records := l.lookup(client, cross)
+		if len(records) != 1 || records[0].Debit != "alice@ANZBank" || records[0].Credit != "bob@CitiBank" ||
+			records[0].Amount.String() != "10.00" || records[0].Status != recordPosted {
+			t.Errorf("tx %s seen by %s = %+v, want the posted transfer of 10.00", cross, client.mspid, records)
+		}
+	}
+
+	// a bank does not learn of the transfers of other banks
+	l.ok(l.anz, "transfer", "alice", "carol@ANZBank", "5")
+	intra := l.lastTx()
+	l.fail(l.citi, "Transaction not found: "+intra, "tx", intra)
+	l.fail(l.anz, "Transaction not found: tx999", "tx", "tx999")
+
+	// a batch transfer is one transaction of several transfers
+	l.ok(l.anz, "batchtransfer", "alice", `[{"credit":"bob@CitiBank","amount":"1"},{"credit":"carol@ANZBank","amount":"2"}]`)
+	batch := l.lastTx()
+	if records := l.lookup(l.anz, batch); len(records) != 2 {
+		t.Errorf("tx %s seen by ANZBank = %+v, want both transfers", batch, records)
+	}
+	if records := l.lookup(l.citi, batch); len(records) != 1 || records[0].Credit != "bob@CitiBank" {
+		t.Errorf("tx %s seen by CitiBank = %+v, want the transfer to bob only", batch, records)
+	}
+
+	// a rollback is marked on the transfer it reverses
+	l.ok(l.supervisor, "rollback", "alice@ANZBank", "bob@CitiBank", cross)
+	reversal := l.lastTx()
+	if records := l.lookup(l.anz, cross); records[0].Status != recordReversed || records[0].ReversedBy != reversal {
+		t.Errorf("tx %s = %+v, want it reversed by %s", cross, records[0], reversal)
+	}
+	if records := l.lookup(l.citi, reversal); len(records) != 1 || records[0].ReversalOf != cross {
+		t.Errorf("tx %s = %+v, want the reversal of %s", reversal, records, cross)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+)
+
+// txStub buffers the writes of a transaction, so that the reads that follow in the
+// same transaction see them; the peer itself only returns the committed state.
+// Functions that change the same keys several times, eg. batchTransfer, run on a
+// txStub and flush it once they succeed. A txStub may wrap another one, which
+// gives savepoints: the writes of the inner one are dropped unless it is flushed.
+type txStub struct {
+	shim.ChaincodeStubInterface
+	writes map[string][]byte // a nil value is a deleted key
+}
+
+// newTxStub starts buffering the writes made through stub.
+func newTxStub(stub shim.ChaincodeStubInterface) *txStub {
+	return &txStub{ChaincodeStubInterface: stub, writes: make(map[string][]byte)}
+}
+
+// GetState returns the buffered value of a key, or the one of the wrapped stub.
+func (s *txStub) GetState(key string) ([]byte, error) {
+	if value, ok := s.writes[key]; ok {
+		return value, nil
+	}
+	return s.ChaincodeStubInterface.GetState(key)
+}
+
+// PutState buffers a write.
+func (s *txStub) PutState(key string, value []byte) error {
+	s.writes[key] = append([]byte{}, value...)
+	return nil
+}
+
+// DelState buffers a delete.
+func (s *txStub) DelState(key string) error {
+	s.writes[key] = nil
+	return nil
+}
+
+// GetStateByRange merges the buffered simple keys into the range of the wrapped stub.
+func (s *txStub) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	it, err := s.ChaincodeStubInterface.GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	return s.merge(it, func(key string) bool {
+		return !strings.HasPrefix(key, compositeKeyNamespace) &&
+			key >= startKey && (endKey == "" || key < endKey)
+	})
+}
+
+// GetStateByPartialCompositeKey merges the buffered composite keys into the query of the wrapped stub.
+func (s *txStub) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	prefix, err := s.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	it, err := s.ChaincodeStubInterface.GetStateByPartialCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	return s.merge(it, func(key string) bool {
+		return strings.HasPrefix(key, prefix)
+	})
+}
+
+// merge drains an iterator of the wrapped stub and overlays the buffered writes
+// of the keys in range, keeping the keys sorted.
+func (s *txStub) merge(it shim.StateQueryIteratorInterface, inRange func(string) bool) (shim.StateQueryIteratorInterface, error) {
+	defer it.Close()
+
+	values := make(map[string][]byte)
+	for it.HasNext() {
+		item, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		values[item.GetKey()] = item.GetValue()
+	}
+	for key, value := range s.writes {
+		if inRange(key) {
+			values[key] = value
+		}
+	}
+
+	// the builtin delete is shadowed by the chaincode function, deleted keys are skipped here
+	merged := &bufferedIterator{}
+	for key, value := range values {
+		if value != nil {
+			merged.items = append(merged.items, &queryresult.KV{Key: key, Value: value})
+		}
+	}
+	sort.Slice(merged.items, func(i, j int) bool { return merged.items[i].Key < merged.items[j].Key })
+	return merged, nil
+}
+
+// flush writes the buffered writes through to the wrapped stub, in key order
+// so that every endorsing peer writes the same sequence.
+func (s *txStub) flush() error {
+	keys := make([]string, 0, len(s.writes))
+	for key := range s.writes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var err error
+		if value := s.writes[key]; value == nil {
+			err = s.ChaincodeStubInterface.DelState(key)
+		} else {
+			err = s.ChaincodeStubInterface.PutState(key, value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	s.writes = make(map[string][]byte)
+	return nil
+}
+
+// bufferedIterator iterates over the result of txStub.merge.
+type bufferedIterator struct {
+	items []*queryresult.KV
+	next  int
+}
+
+func (it *bufferedIterator) HasNext() bool {
+	return it.next < len(it.items)
+}
+
+func (it *bufferedIterator) Next() (*queryresult.KV, error) {
+	it.next++
+	return it.items[it.next-1], nil
+}
+
+func (it *bufferedIterator) Close() error {
+	return nil
+}
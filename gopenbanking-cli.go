@@ -1,12 +1,29 @@
 package main
 
 import (
+  "bufio"
   "flag"
   "fmt"
+  "os"
+  "strconv"
+  "strings"
 
   "github.com/Miosolo/gopenbanking/app"
 )
 
+// freeText holds the functions whose last param is a free text, eg. a reason,
+// and the number of params before it; the words after them are joined back into the text
+var freeText = map[string]int{
+  "freeze":        1,
+  "unfreeze":      1,
+  "activate":      1,
+  "reject":        2,
+  "rejectremit":   1,
+  "dispute":       2,
+  "disputenote":   1,
+  "decidedispute": 2,
+}
+
 // provides an interactive cli interface to multi-org users
 func main() {
   // define the flags & parse the params
@@ -29,6 +46,31 @@ func main() {
     fmt.Println(`==========INSTRUCTIONS==========
   - "rollback" + debit account + credit account + transaction ID
   - "query" + "in" / "out" + account
+  - "querypage" + "in" / "out" + account + page size
+  - "history" + "in" / "out" / "all" + account + [from] + [to] + [min] + [max], "*" skips a filter
+  - "setrate" + base currency + quote currency + rate
+  - "issue" + bank + currency + amount: fund the reserve of a bank
+  - "supply" + [currency]: check the money in the accounts against the money issued
+  - "positions": what the banks owe each other since the last settlement
+  - "settle": close the settlement cycle into net obligations between the banks
+  - "settlements"
+  - "rate" + base currency + quote currency
+  - "tx" + transaction ID
+  - "freeze" + account + reason
+  - "unfreeze" + account + reason
+  - "statuslog" + account
+  - "setpolicy" + function + comma separated roles, eg. teller,bankadmin; "*" removes the policy
+  - "holds" + account
+  - "orders" + account
+  - "payments" + account
+  - "remits"
+  - "sweepremits": close the remittances nobody answered in time
+  - "disputenote" + dispute ID + note
+  - "reviewdispute" + dispute ID
+  - "decidedispute" + dispute ID + "uphold" / "reject" + decision: upholding rolls the transfer back
+  - "disputes" + [status, "*" for all]: the open and under review ones by default
+  - "policies"
+  - "watch" + [event filter, eg. "transfer|rollback"]: print events until Enter is pressed
   - "exit": terminate the loop and exit
 <account format>: <bank-wise account>@<bank>, eg. abc123@ANZBank
 ================================`)
@@ -36,38 +78,205 @@ func main() {
     fmt.Println(`==========INSTRUCTIONS==========
 Functions and parameters of the ANZ-CITI Banking Network:
   - "get" + account
-  - "add" + account + value
-  - "reduce" + account + value
-  - "create" + account + inititial value
-  - "delete" + account
-  - "tranfer" + account + **full account** + tranfer amount
+  - "add" + account + value, a deposit paid out of the bank reserve, bank admins and tellers only + [idempotency key]
+  - "reduce" + account + value, paid back into the bank reserve + [idempotency key]
+  - "create" + account + inititial value out of the bank reserve, 0 unless a bank admin or teller + [currency, CNY by default], pending until money comes in
+  - "delete" + account: close and archive an account without money, live holds, payments, orders, remittances or disputes
+  - "activate" + account + reason: activate a pending or dormant account
+  - "dormant" + idle time, eg. 8760h: make dormant the accounts without movements since then
+  - "chown" + account + identity of the new owner
+  - "setcredit" + account + credit limit, 0 removes the overdraft
+  - "setlimits" + account / "*" for the bank + per transfer max + daily amount max + daily count max, "*" is unlimited + [currency of the bank limits, CNY by default]
+  - "setfees" + "intra" / "cross" + tiers, eg. [{"from":"0","flat":"1","rate":"0.001"}]; "*" removes the fees + [currency, CNY by default]
+  - "fees" + [currency, CNY by default]
+  - "positions": what the bank owes or is owed since the last settlement
+  - "settlements"
+  - "setproduct" + product + annual rate, eg. 0.035 + "ACT/365" / "ACT/360" / "ACT/ACT" / "30/360"
+  - "products"
+  - "assignproduct" + account + product, "*" removes the product
+  - "accrue": accrue the interest of the bank up to today, posting the finished months
+  - "tranfer" + account + **full account** + tranfer amount + [idempotency key], pending approval above the threshold
+  - "approve" + account + payment ID, bank admins and approvers other than the submitter only
+  - "reject" + account + payment ID + reason, the owner of the account or a bank admin only
+  - "payments" + account
+  - "remit" + account + **full account at another bank** + amount + expiry, eg. 72h: the credit bank has to accept it
+  - "acceptremit" + remittance ID
+  - "rejectremit" + remittance ID + reason
+  - "remits"
+  - "sweepremits"
+  - "dispute" + transaction ID + **full credit account** + reason
+  - "disputenote" + dispute ID + note
+  - "disputes" + [status, "*" for all]: the open and under review ones by default
+  - "setapproval" + currency + threshold, "*" removes it + approvers + expiry, eg. 72h
+  - "approvals"
+  - "batch" + account + CSV file of **full account**,amount lines
+  - "hold" + account + **full beneficiary account** + amount + expiry, eg. 72h
+  - "capture" + **full account** + hold ID + [amount, the whole hold by default]
+  - "release" + **full account** + hold ID
+  - "holds" + account
+  - "order" + account + **full account** + amount + "daily" / "weekly" / "monthly" / "yearly" + first run, "*" for now + [end]
+  - "cancelorder" + account + order ID
+  - "orders" + account
+  - "runorders": execute the standing orders due now
   - "query" + "in" / "out" + account
+  - "querypage" + "in" / "out" + account + page size
+  - "history" + "in" / "out" / "all" + account + [from] + [to] + [min] + [max], "*" skips a filter
+  - "rate" + base currency + quote currency
+  - "tx" + transaction ID
+  - "statuslog" + account
+  - "policies"
+  - "watch" + [event filter, eg. "transfer|rollback"]: print events until Enter is pressed
   - "exit": terminate the loop and exit
 <full account format>: <account>@<bank>, eg. abc123@ANZBank
+<idempotency key>: any unique string, retrying with the same key returns the first result instead of running again
 ================================`)
   }
 
   // start loop
+  stdin := bufio.NewScanner(os.Stdin)
   for true {
     // read the stdin input
     fmt.Printf("Enter the function & params: ")
-    var fn string
-    var args [3]string // max args: 3 due to the chaincode
-    // inputCnt - 1 = args Count
-    inputCnt, _ := fmt.Scanln(&fn, &args[0], &args[1], &args[2])
+    if !stdin.Scan() {
+      fmt.Println("bye")
+      return
+    }
+    input := strings.Fields(stdin.Text())
 
-    if inputCnt == 0 {
+    if len(input) == 0 {
       continue
-    } else if fn == "exit" {
+    }
+    fn, args := input[0], input[1:]
+    if fn == "exit" {
       fmt.Println("bye")
       return
+    } else if fn == "querypage" {
+      pageHistory(ap, stdin, args)
+      continue
+    } else if fn == "history" {
+      filterHistory(ap, args)
+      continue
+    } else if fn == "batch" {
+      batchTransfer(ap, args)
+      continue
+    } else if fn == "watch" {
+      watchEvents(ap, stdin, args)
+      continue
+    } else if fixed, ok := freeText[fn]; ok && len(args) > fixed+1 {
+      // the reason, note or decision may contain spaces
+      args = append(args[:fixed:fixed], strings.Join(args[fixed:], " "))
     }
 
     // else, invoke the smart contract
-    if response, err := ap.Invoke(fn, args[0:inputCnt-1]); err != nil {
+    if response, err := ap.Invoke(fn, args); err != nil {
       fmt.Println("Invoking chaincode failed: " + err.Error())
     } else {
       fmt.Println("Response: " + response)
     }
   }
 }
+
+// pageHistory prints the history one page at a time until the user stops
+func pageHistory(ap *app.Provider, stdin *bufio.Scanner, args []string) {
+  if len(args) != 3 {
+    fmt.Println(`Usage: "querypage" + "in" / "out" + account + page size`)
+    return
+  }
+  pageSize, err := strconv.Atoi(args[2])
+  if err != nil {
+    fmt.Println("Invalid page size: " + args[2])
+    return
+  }
+
+  bookmark := ""
+  for true {
+    page, err := ap.QueryPage(args[0], args[1], pageSize, bookmark)
+    if err != nil {
+      fmt.Println("Invoking chaincode failed: " + err.Error())
+      return
+    }
+    for _, entry := range page.Entries {
+      fmt.Println(entry)
+    }
+
+    if page.Bookmark == "" {
+      fmt.Println("-- end of history --")
+      return
+    }
+    fmt.Printf("-- press Enter for the next page, or type q to stop: ")
+    if !stdin.Scan() || strings.TrimSpace(stdin.Text()) != "" {
+      return
+    }
+    bookmark = page.Bookmark
+  }
+}
+
+// filterHistory prints the history matching the optional filters
+func filterHistory(ap *app.Provider, args []string) {
+  if len(args) < 2 || len(args) > 6 {
+    fmt.Println(`Usage: "history" + "in" / "out" / "all" + account + [from] + [to] + [min] + [max]`)
+    return
+  }
+  filters := make([]string, 4)
+  copy(filters, args[2:])
+
+  entries, err := ap.History(args[0], args[1], filters[0], filters[1], filters[2], filters[3])
+  if err != nil {
+    fmt.Println("Invoking chaincode failed: " + err.Error())
+    return
+  }
+  for _, entry := range entries {
+    fmt.Println(entry)
+  }
+  fmt.Printf("-- %d entries --\n", len(entries))
+}
+
+// watchEvents prints the chaincode events until the user presses Enter
+func watchEvents(ap *app.Provider, stdin *bufio.Scanner, args []string) {
+  filter := ".*"
+  if len(args) > 0 {
+    filter = args[0]
+  }
+  events, unsubscribe, err := ap.Subscribe(filter)
+  if err != nil {
+    fmt.Println("Subscribing events failed: " + err.Error())
+    return
+  }
+
+  fmt.Println("-- watching events, press Enter to stop --")
+  done := make(chan struct{})
+  go func() {
+    defer close(done)
+    for e := range events {
+      fmt.Printf("[%s] %s %s at %s\n", e.TxID, e.Type, e.Legs, e.Time)
+    }
+  }()
+  stdin.Scan()
+  unsubscribe()
+  <-done
+}
+
+// batchTransfer pays every line of a CSV file from one account in a single transaction
+func batchTransfer(ap *app.Provider, args []string) {
+  if len(args) != 2 {
+    fmt.Println(`Usage: "batch" + account + CSV file`)
+    return
+  }
+  file, err := os.Open(args[1])
+  if err != nil {
+    fmt.Println("Cannot open the batch: " + err.Error())
+    return
+  }
+  defer file.Close()
+
+  legs, err := app.ReadBatchCSV(file)
+  if err != nil {
+    fmt.Println("Cannot read the batch: " + err.Error())
+    return
+  }
+  if response, err := ap.BatchTransfer(args[0], legs); err != nil {
+    fmt.Println("Invoking chaincode failed: " + err.Error())
+  } else {
+    fmt.Println("Response: " + response)
+  }
+}